@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var analyzeCommand = &command{
+	name:    "analyze",
+	args:    "[path ...]",
+	summary: "Compare the structure and bytes of .splice files",
+	doc: "Analyze decodes the files and aligns their bytes by the decoded structure,\n" +
+		"so that the header and every track record are compared whatever the lengths\n" +
+		"of the fields before them. For every aligned byte it prints the offsets it\n" +
+		"is found at, whether all files agree on it and how many files hold each\n" +
+		"value, written as a character, in decimal and in hexadecimal. Bytes the\n" +
+		"decoder does not understand are compared as unmapped.\n\n" +
+		"Analyze also looks for the offsets at which every file encodes a decoded\n" +
+		"setting that differs between the files, such as the tempo, and for integers\n" +
+		"counting the bytes that follow them, in either byte order.\n\n" +
+		"Directories are searched for .splice files, the current directory\n" +
+		"by default.",
+	setup: func(fs *flag.FlagSet) func(*env, []string) error {
+		asJSON := fs.Bool("json", false, "Print the analysis as JSON")
+		dump := fs.Bool("dump", false, "Include an annotated hexdump of every file")
+		return func(e *env, args []string) error {
+			if len(args) == 0 {
+				args = []string{"."}
+			}
+			layouts, err := readLayouts(args)
+			if err != nil {
+				return err
+			}
+			if len(layouts) == 0 {
+				return fmt.Errorf("no .splice files found")
+			}
+			a := analyze(layouts, *dump)
+			if *asJSON {
+				enc := json.NewEncoder(e.stdout)
+				enc.SetIndent("", "\t")
+				return enc.Encode(a)
+			}
+			return a.writeText(e.stdout)
+		}
+	},
+}
+
+// readLayouts decodes the files named by paths, and the .splice
+// files in the directories named by paths.
+func readLayouts(paths []string) ([]*layout, error) {
+	var names []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			names = append(names, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.splice"))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, matches...)
+	}
+	sort.Strings(names)
+	var layouts []*layout
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		layouts = append(layouts, decodeLayout(name, b))
+	}
+	return layouts, nil
+}
+
+// An analysis compares the bytes of files.
+type analysis struct {
+	Files    []fileSummary  `json:"files"`
+	Settings []settingMatch `json:"settings"`
+	Lengths  []lengthField  `json:"lengths"`
+	Bytes    []byteStats    `json:"bytes"`
+	Dumps    []hexdump      `json:"dumps,omitempty"`
+}
+
+type fileSummary struct {
+	Name  string `json:"name"`
+	Size  int    `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// A settingMatch is an offset at which every file encodes a decoded setting.
+type settingMatch struct {
+	Setting  string `json:"setting"`
+	Encoding string `json:"encoding"`
+	Offset   int64  `json:"offset"`
+	Length   int    `json:"length"`
+	Field    string `json:"field"` // decoded field at the offset in the first file
+}
+
+// A lengthField is an integer that holds the number of bytes following it,
+// plus Adjust, in Files of the files.
+type lengthField struct {
+	Encoding string `json:"encoding"`
+	Offset   int64  `json:"offset"`
+	Length   int    `json:"length"`
+	Adjust   int64  `json:"adjust"`
+	Files    int    `json:"files"`
+	Field    string `json:"field"`
+}
+
+// A byteStats counts the values of the byte in a slot of the files.
+type byteStats struct {
+	Slot    string       `json:"slot"`
+	Offsets []int64      `json:"offsets"` // of the byte in every file, or -1
+	Uniform bool         `json:"uniform"` // whether every file has the same byte in the slot
+	Values  []valueCount `json:"values"`
+}
+
+type valueCount struct {
+	Value byte `json:"value"`
+	Count int  `json:"count"`
+}
+
+func analyze(layouts []*layout, dump bool) *analysis {
+	a := &analysis{Settings: []settingMatch{}, Lengths: []lengthField{}}
+	for _, l := range layouts {
+		s := fileSummary{Name: l.name, Size: len(l.data)}
+		if l.err != nil {
+			s.Error = l.err.Error()
+		}
+		a.Files = append(a.Files, s)
+		if dump {
+			a.Dumps = append(a.Dumps, dumpLayout(l))
+		}
+	}
+	a.Bytes = alignBytes(layouts)
+	a.Settings = matchSettings(layouts)
+	a.Lengths = findLengths(layouts)
+	return a
+}
+
+// alignBytes returns the stats of every slot of the files in order.
+func alignBytes(layouts []*layout) []byteStats {
+	index := make(map[slot]int)
+	var slots []slot
+	var stats []byteStats
+	for i, l := range layouts {
+		for offset, s := range l.slots() {
+			j, ok := index[s]
+			if !ok {
+				j = len(stats)
+				index[s] = j
+				slots = append(slots, s)
+				o := byteStats{Slot: s.String(), Offsets: make([]int64, len(layouts))}
+				for k := range o.Offsets {
+					o.Offsets[k] = -1
+				}
+				stats = append(stats, o)
+			}
+			stats[j].Offsets[i] = int64(offset)
+			stats[j].add(l.data[offset])
+		}
+	}
+	for i := range stats {
+		stats[i].Uniform = len(stats[i].Values) == 1 && stats[i].Values[0].Count == len(layouts)
+	}
+	order := make([]int, len(stats))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return slots[order[i]].less(slots[order[j]]) })
+	sorted := make([]byteStats, len(stats))
+	for i, j := range order {
+		sorted[i] = stats[j]
+	}
+	return sorted
+}
+
+// add counts v, keeping the values in ascending order.
+func (o *byteStats) add(v byte) {
+	i := sort.Search(len(o.Values), func(i int) bool { return o.Values[i].Value >= v })
+	if i < len(o.Values) && o.Values[i].Value == v {
+		o.Values[i].Count++
+		return
+	}
+	o.Values = append(o.Values, valueCount{})
+	copy(o.Values[i+1:], o.Values[i:])
+	o.Values[i] = valueCount{v, 1}
+}
+
+// An encoding writes a setting as bytes.
+type encoding struct {
+	kind  string // "uint", "float" or "string"
+	size  int    // of numbers in bytes
+	order binary.ByteOrder
+}
+
+// intEncodings write integers in both byte orders.
+var intEncodings = []encoding{
+	{"uint", 1, nil},
+	{"uint", 2, binary.LittleEndian},
+	{"uint", 2, binary.BigEndian},
+	{"uint", 4, binary.LittleEndian},
+	{"uint", 4, binary.BigEndian},
+	{"uint", 8, binary.LittleEndian},
+	{"uint", 8, binary.BigEndian},
+}
+
+var floatEncodings = []encoding{
+	{"float", 4, binary.LittleEndian},
+	{"float", 4, binary.BigEndian},
+}
+
+var stringEncodings = []encoding{{kind: "string"}}
+
+func (enc encoding) String() string {
+	switch {
+	case enc.kind == "string":
+		return "string"
+	case enc.order == nil:
+		return fmt.Sprintf("%s%d", enc.kind, 8*enc.size)
+	case enc.order == binary.LittleEndian:
+		return fmt.Sprintf("%s%d little-endian", enc.kind, 8*enc.size)
+	}
+	return fmt.Sprintf("%s%d big-endian", enc.kind, 8*enc.size)
+}
+
+// encode returns the encoding of a number or string,
+// or nil if the number is out of range.
+func (enc encoding) encode(v float64, str string) []byte {
+	switch enc.kind {
+	case "string":
+		return []byte(str)
+	case "float":
+		b := make([]byte, 4)
+		enc.order.PutUint32(b, math.Float32bits(float32(v)))
+		return b
+	}
+	if v < 0 || v >= math.Exp2(float64(8*enc.size)) || v != math.Trunc(v) {
+		return nil
+	}
+	b := make([]byte, enc.size)
+	switch enc.size {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		enc.order.PutUint16(b, uint16(v))
+	case 4:
+		enc.order.PutUint32(b, uint32(v))
+	case 8:
+		enc.order.PutUint64(b, uint64(v))
+	}
+	return b
+}
+
+// decodeUint decodes an unsigned integer of the encoding from b.
+func (enc encoding) decodeUint(b []byte) uint64 {
+	switch enc.size {
+	case 2:
+		return uint64(enc.order.Uint16(b))
+	case 4:
+		return uint64(enc.order.Uint32(b))
+	case 8:
+		return enc.order.Uint64(b)
+	}
+	return uint64(b[0])
+}
+
+// A setting is a value decoded from every file.
+type setting struct {
+	name      string
+	encodings []encoding
+	value     func(l *layout) (float64, string)
+}
+
+var settings = []setting{
+	{"tempo", floatEncodings, func(l *layout) (float64, string) {
+		return float64(l.pattern.Tempo), ""
+	}},
+	{"hardware version", stringEncodings, func(l *layout) (float64, string) {
+		return 0, l.pattern.HardwareVersion
+	}},
+	{"tracks", intEncodings, func(l *layout) (float64, string) {
+		return float64(len(l.pattern.Tracks)), ""
+	}},
+	{"steps", intEncodings, func(l *layout) (float64, string) {
+		return float64(l.pattern.Steps()), ""
+	}},
+	{"file size", intEncodings, func(l *layout) (float64, string) {
+		return float64(len(l.data)), ""
+	}},
+}
+
+// matchSettings returns the offsets at which every file encodes the
+// settings of its first pattern that differ between the files.
+// Settings every file shares are skipped, as any bytes that happen to
+// hold their value would match.
+func matchSettings(layouts []*layout) []settingMatch {
+	var decoded []*layout
+	for _, l := range layouts {
+		if l.pattern != nil {
+			decoded = append(decoded, l)
+		}
+	}
+	matches := []settingMatch{}
+	if len(decoded) < 2 {
+		return matches
+	}
+	for _, s := range settings {
+		values := make(map[string]bool)
+		for _, l := range decoded {
+			v, str := s.value(l)
+			values[fmt.Sprint(v, str)] = true
+		}
+		if len(values) < 2 {
+			continue
+		}
+		var found []settingMatch
+		for _, enc := range s.encodings {
+			encoded := make([][]byte, len(decoded))
+			for i, l := range decoded {
+				encoded[i] = enc.encode(s.value(l))
+			}
+			for _, offset := range commonOffsets(decoded, encoded) {
+				found = append(found, settingMatch{
+					Setting:  s.name,
+					Encoding: enc.String(),
+					Offset:   offset,
+					Length:   len(encoded[0]),
+					Field:    fieldAt(decoded[0], offset),
+				})
+			}
+		}
+		matches = append(matches, widest(found)...)
+	}
+	return matches
+}
+
+// commonOffsets returns the offsets at which every file i holds encoded[i].
+func commonOffsets(layouts []*layout, encoded [][]byte) []int64 {
+	var offsets []int64
+	for _, e := range encoded {
+		if len(e) == 0 {
+			return nil
+		}
+	}
+	first := layouts[0].data
+	for o := 0; o+len(encoded[0]) <= len(first); o++ {
+		all := true
+		for i, l := range layouts {
+			if o+len(encoded[i]) > len(l.data) || !bytes.Equal(l.data[o:o+len(encoded[i])], encoded[i]) {
+				all = false
+				break
+			}
+		}
+		if all {
+			offsets = append(offsets, int64(o))
+		}
+	}
+	return offsets
+}
+
+// widest drops the matches within the bytes of wider matches,
+// such as the low byte of an integer matching as a uint8.
+func widest(matches []settingMatch) []settingMatch {
+	var kept []settingMatch
+	for i, m := range matches {
+		within := false
+		for j, n := range matches {
+			if i != j && n.Length > m.Length && m.Offset >= n.Offset && m.Offset+int64(m.Length) <= n.Offset+int64(n.Length) {
+				within = true
+				break
+			}
+		}
+		if !within {
+			kept = append(kept, m)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Offset < kept[j].Offset })
+	return kept
+}
+
+// maxAdjust bounds the difference between the value of a length field
+// and the number of bytes following it.
+const maxAdjust = 64
+
+// findLengths returns the integers that count the bytes following them
+// in most files. The number of bytes may be off by a constant, such as
+// when the length counts a field preceding it, but the files must differ
+// in length so that any constant does not match.
+func findLengths(layouts []*layout) []lengthField {
+	shortest := len(layouts[0].data)
+	for _, l := range layouts {
+		if len(l.data) < shortest {
+			shortest = len(l.data)
+		}
+	}
+	var found []lengthField
+	for _, enc := range intEncodings {
+		size := enc.size
+		for o := 0; o+size <= shortest; o++ {
+			counts := make(map[int64]int)
+			sizes := make(map[int64]map[int]bool)
+			for _, l := range layouts {
+				v := enc.decodeUint(l.data[o : o+size])
+				if v == 0 || v > uint64(len(l.data))+maxAdjust {
+					continue
+				}
+				adjust := int64(v) - int64(len(l.data)-o-size)
+				if adjust < -maxAdjust || adjust > maxAdjust {
+					continue
+				}
+				counts[adjust]++
+				if sizes[adjust] == nil {
+					sizes[adjust] = make(map[int]bool)
+				}
+				sizes[adjust][len(l.data)] = true
+			}
+			for adjust, n := range counts {
+				if n < 2 || 2*n <= len(layouts) || len(sizes[adjust]) < 2 {
+					continue
+				}
+				found = append(found, lengthField{
+					Encoding: enc.String(),
+					Offset:   int64(o),
+					Length:   size,
+					Adjust:   adjust,
+					Files:    n,
+					Field:    fieldAt(layouts[0], int64(o)),
+				})
+			}
+		}
+	}
+	// The low bytes of a length match as narrower lengths.
+	var kept []lengthField
+	for i, f := range found {
+		within := false
+		for j, g := range found {
+			if i != j && g.Length > f.Length && g.Adjust == f.Adjust &&
+				f.Offset >= g.Offset && f.Offset+int64(f.Length) == g.Offset+int64(g.Length) {
+				within = true
+				break
+			}
+		}
+		if !within {
+			kept = append(kept, f)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Offset < kept[j].Offset })
+	if kept == nil {
+		kept = []lengthField{}
+	}
+	return kept
+}
+
+// fieldAt describes the decoded field holding the byte at offset.
+func fieldAt(l *layout, offset int64) string {
+	s, ok := l.spanAt(offset)
+	if !ok {
+		return ""
+	}
+	if offset == s.Offset {
+		return s.String()
+	}
+	return fmt.Sprintf("%s+%d", s, offset-s.Offset)
+}
+
+// A hexdump is the bytes of a file, 16 to a row, with the decoded
+// fields starting in every row and their offsets in hexadecimal.
+type hexdump struct {
+	File string    `json:"file"`
+	Rows []dumpRow `json:"rows"`
+}
+
+type dumpRow struct {
+	Offset int64    `json:"offset"`
+	Bytes  string   `json:"bytes"` // in hexadecimal
+	Text   string   `json:"text"`  // with unprintable bytes as dots
+	Fields []string `json:"fields,omitempty"`
+}
+
+const dumpWidth = 16
+
+func dumpLayout(l *layout) hexdump {
+	d := hexdump{File: l.name}
+	for o := 0; o < len(l.data); o += dumpWidth {
+		end := o + dumpWidth
+		if end > len(l.data) {
+			end = len(l.data)
+		}
+		r := dumpRow{Offset: int64(o), Bytes: fmt.Sprintf("% x", l.data[o:end]), Text: printable(l.data[o:end])}
+		for _, s := range l.spans {
+			if s.Offset >= int64(o) && s.Offset < int64(end) {
+				r.Fields = append(r.Fields, fmt.Sprintf("%x:%s", s.Offset, s))
+			}
+		}
+		d.Rows = append(d.Rows, r)
+	}
+	return d
+}
+
+// printable returns b as text with bytes other than printable ASCII as dots.
+func printable(b []byte) string {
+	text := make([]byte, len(b))
+	for i, c := range b {
+		text[i] = '.'
+		if c >= ' ' && c <= '~' {
+			text[i] = c
+		}
+	}
+	return string(text)
+}
+
+func (a *analysis) writeText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Files:")
+	for _, f := range a.Files {
+		fmt.Fprintf(tw, "\t%s\t%d bytes\t%s\n", f.Name, f.Size, f.Error)
+	}
+	if len(a.Settings) > 0 {
+		fmt.Fprintln(tw, "\nSettings:")
+		for _, m := range a.Settings {
+			fmt.Fprintf(tw, "\t%s\t%s\t%s\t%s\n", m.Setting, byteRange(m.Offset, m.Length), m.Encoding, m.Field)
+		}
+	}
+	if len(a.Lengths) > 0 {
+		fmt.Fprintln(tw, "\nLengths:")
+		for _, f := range a.Lengths {
+			what := "bytes to end of file"
+			if f.Adjust != 0 {
+				what += fmt.Sprintf(" %+d", f.Adjust)
+			}
+			fmt.Fprintf(tw, "\t%s\t%s\t%s\t%d of %d files\t%s\n",
+				byteRange(f.Offset, f.Length), f.Encoding, what, f.Files, len(a.Files), f.Field)
+		}
+	}
+	fmt.Fprintln(tw, "\nBytes:")
+	for _, b := range a.Bytes {
+		fmt.Fprintf(tw, "\t%s\t%s\t%v\t%s\n", b.Slot, offsets(b.Offsets), b.Uniform, values(b.Values))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, d := range a.Dumps {
+		fmt.Fprintf(w, "\n%s:\n", d.File)
+		for _, r := range d.Rows {
+			line := fmt.Sprintf("%08x  %-*s  |%-*s|  %s", r.Offset, 3*dumpWidth-1, r.Bytes,
+				dumpWidth, r.Text, strings.Join(r.Fields, ", "))
+			fmt.Fprintln(w, strings.TrimRight(line, " "))
+		}
+	}
+	return nil
+}
+
+// byteRange writes the offsets of n bytes starting at offset.
+func byteRange(offset int64, n int) string {
+	if n == 1 {
+		return strconv.FormatInt(offset, 10)
+	}
+	return fmt.Sprintf("%d-%d", offset, offset+int64(n)-1)
+}
+
+// offsets writes the distinct offsets of a slot.
+func offsets(all []int64) string {
+	var distinct []string
+	seen := make(map[int64]bool)
+	for _, o := range all {
+		if o >= 0 && !seen[o] {
+			seen[o] = true
+			distinct = append(distinct, strconv.FormatInt(o, 10))
+		}
+	}
+	return strings.Join(distinct, ",")
+}
+
+func values(counts []valueCount) string {
+	fields := make([]string, len(counts))
+	for i, c := range counts {
+		fields[i] = fmt.Sprintf("%q/%d/%X:%d", rune(c.Value), c.Value, c.Value, c.Count)
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"testing"
+)
+
+var fixtureDir = path.Join("..", "..", "encoding", "drum", "patterns")
+
+func analyzeFixtures(t *testing.T, dump bool) *analysis {
+	layouts, err := readLayouts([]string{fixtureDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layouts) != 5 {
+		t.Fatalf("Expected 5 fixtures but found %v", len(layouts))
+	}
+	return analyze(layouts, dump)
+}
+
+func TestAlignBytes(t *testing.T) {
+	a := analyzeFixtures(t, false)
+	tData := []struct {
+		slot    string
+		offsets []int64
+		uniform bool
+	}{
+		{"SPLICE chunk ID[0]", []int64{0, 0, 0, 0, 0}, true},
+		{"SPLICE tempo[0]", []int64{46, 46, 46, 46, 46}, false},
+		// Track names differ in length, so the steps following them
+		// are aligned at different offsets.
+		{"SPLICE track 1 name[0]", []int64{80, 80, 80, 83, 80}, false},
+		{"SPLICE track 1 name[4]", []int64{84, 84, -1, -1, 84}, false},
+		{"SPLICE track 1 steps[0]", []int64{85, 85, 84, 87, 85}, false},
+		{"pattern 1 unmapped[0]", []int64{-1, -1, -1, -1, 115}, false},
+	}
+	stats := make(map[string]byteStats)
+	for _, b := range a.Bytes {
+		stats[b.Slot] = b
+	}
+	for _, exp := range tData {
+		b, ok := stats[exp.slot]
+		if !ok {
+			t.Fatalf("Expected slot %v to be aligned", exp.slot)
+		}
+		if fmt.Sprint(b.Offsets) != fmt.Sprint(exp.offsets) || b.Uniform != exp.uniform {
+			t.Fatalf("Expected slot %v at offsets %v, uniform %v but received %v, %v",
+				exp.slot, exp.offsets, exp.uniform, b.Offsets, b.Uniform)
+		}
+	}
+	// Slots are ordered by the decoded structure, unmapped bytes last.
+	if last := a.Bytes[len(a.Bytes)-1].Slot; last != "pattern 1 unmapped[16]" {
+		t.Fatalf("Expected the last slot to be pattern 1 unmapped[16] but received %v", last)
+	}
+}
+
+func TestMatchSettings(t *testing.T) {
+	a := analyzeFixtures(t, false)
+	expected := []settingMatch{
+		{Setting: "hardware version", Encoding: "string", Offset: 14, Length: 11, Field: "hardware version"},
+		{Setting: "tempo", Encoding: "float32 little-endian", Offset: 46, Length: 4, Field: "tempo"},
+	}
+	sort.Slice(a.Settings, func(i, j int) bool { return a.Settings[i].Setting < a.Settings[j].Setting })
+	if fmt.Sprint(a.Settings) != fmt.Sprint(expected) {
+		t.Fatalf("Expected settings %+v but received %+v", expected, a.Settings)
+	}
+}
+
+func TestFindLengths(t *testing.T) {
+	a := analyzeFixtures(t, false)
+	// The truncated pattern_5.splice declares more bytes than it holds.
+	expected := []lengthField{
+		{Encoding: "uint64 big-endian", Offset: 6, Length: 8, Adjust: 0, Files: 4, Field: "chunk length"},
+	}
+	if fmt.Sprint(a.Lengths) != fmt.Sprint(expected) {
+		t.Fatalf("Expected lengths %+v but received %+v", expected, a.Lengths)
+	}
+}
+
+func TestAnalyzeJSON(t *testing.T) {
+	b := new(bytes.Buffer)
+	enc := json.NewEncoder(b)
+	if err := enc.Encode(analyzeFixtures(t, true)); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string][]map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	tData := []struct {
+		key    string
+		length int
+		fields []string // of the first element
+	}{
+		{"files", 5, []string{"name", "size"}},
+		{"settings", 2, []string{"encoding", "field", "length", "offset", "setting"}},
+		{"lengths", 1, []string{"adjust", "encoding", "field", "files", "length", "offset"}},
+		{"bytes", len(analyzeFixtures(t, false).Bytes), []string{"offsets", "slot", "uniform", "values"}},
+		{"dumps", 5, []string{"file", "rows"}},
+	}
+	if len(decoded) != len(tData) {
+		t.Fatalf("Expected %v keys but received %v", len(tData), len(decoded))
+	}
+	for _, exp := range tData {
+		list := decoded[exp.key]
+		if len(list) != exp.length {
+			t.Fatalf("Expected %v %v but received %v", exp.length, exp.key, len(list))
+		}
+		var fields []string
+		for f := range list[0] {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		if fmt.Sprint(fields) != fmt.Sprint(exp.fields) {
+			t.Fatalf("Expected %v to have fields %v but received %v", exp.key, exp.fields, fields)
+		}
+	}
+	if rows := decoded["dumps"][0]["rows"].([]interface{}); len(rows) != (211+15)/16 {
+		t.Fatalf("Expected %v rows dumping pattern_1.splice but received %v", (211+15)/16, len(rows))
+	}
+}
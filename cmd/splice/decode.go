@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"splice/encoding/drum"
+)
+
+var decodeCommand = &command{
+	name:    "decode",
+	args:    "[file]",
+	summary: "Decode a .splice file to its text backup",
+	setup: func(fs *flag.FlagSet) func(*env, []string) error {
+		out := fs.String("o", "", "Path of the text backup to write instead of standard output")
+		return func(e *env, args []string) error {
+			name, b, err := readInput(e, args)
+			if err != nil {
+				return err
+			}
+			p, err := parsePattern(name, b)
+			if err != nil {
+				return err
+			}
+			return writeOutput(e, *out, []byte(p.String()))
+		}
+	},
+}
+
+var encodeCommand = &command{
+	name:    "encode",
+	args:    "[file]",
+	summary: "Encode a text backup as a .splice file",
+	setup: func(fs *flag.FlagSet) func(*env, []string) error {
+		out := fs.String("o", "", "Path of the .splice file to write instead of standard output")
+		return func(e *env, args []string) error {
+			name, b, err := readInput(e, args)
+			if err != nil {
+				return err
+			}
+			p, err := parsePattern(name, b)
+			if err != nil {
+				return err
+			}
+			if *out != "" && *out != "-" {
+				return drum.EncodeFile(*out, *p)
+			}
+			b, err = encode(p)
+			if err != nil {
+				return err
+			}
+			_, err = e.stdout.Write(b)
+			return err
+		}
+	},
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"splice/encoding/drum"
+)
+
+var diffCommand = &command{
+	name:    "diff",
+	args:    "old new\n       splice diff [flags] path old-file old-hex old-mode new-file new-hex new-mode",
+	summary: "Print the differences between two patterns",
+	doc: "Diff prints a grid of the tracks of the patterns, marking removed lines with -\n" +
+		"and added lines with + and highlighting the steps that changed. The patterns\n" +
+		"may be .splice files or text backups.\n\n" +
+		"To show the differences of .splice files in git, add '*.splice diff=splice'\n" +
+		"to .gitattributes and run\n\n" +
+		"\tgit config diff.splice.command 'splice diff'\n\n" +
+		"Diff exits with status 1 if the patterns differ, unless it is run by git.",
+	setup: func(fs *flag.FlagSet) func(*env, []string) error {
+		color := fs.String("color", "auto", "Color the output: `auto`, always or never")
+		return func(e *env, args []string) error {
+			var oldName, newName, oldPath, newPath string
+			switch len(args) {
+			case 2:
+				oldName, newName, oldPath, newPath = args[0], args[1], args[0], args[1]
+			case 7, 9:
+				// Run by git as an external diff driver, with the
+				// new path and similarity of renames following.
+				oldName, newName, oldPath, newPath = "a/"+args[0], "b/"+args[0], args[1], args[4]
+				if len(args) == 9 {
+					newName = "b/" + args[7]
+				}
+			default:
+				return errUsage
+			}
+			a, err := readPattern(oldPath)
+			if err != nil {
+				return err
+			}
+			b, err := readPattern(newPath)
+			if err != nil {
+				return err
+			}
+			changes := drum.Diff(a, b)
+			if len(changes) == 0 {
+				return nil
+			}
+			d := differ{color: colorOutput(e, *color)}
+			d.header("--- "+oldName, "+++ "+newName)
+			d.grids(a, b, changes)
+			if _, err := e.stdout.Write(d.Bytes()); err != nil {
+				return err
+			}
+			// Exit like diff(1) unless git is reading the output.
+			if len(args) == 2 {
+				return exitStatus(1)
+			}
+			return nil
+		}
+	},
+}
+
+const (
+	red     = "\x1b[31m"
+	green   = "\x1b[32m"
+	bold    = "\x1b[1m"
+	reverse = "\x1b[7m"
+	noColor = "\x1b[0m"
+)
+
+// A differ writes the lines of a diff.
+type differ struct {
+	bytes.Buffer
+	color bool
+}
+
+func (d *differ) header(lines ...string) {
+	for _, l := range lines {
+		d.line(bold, "", l, nil)
+	}
+}
+
+// line writes a line with a prefix in a color, highlighting the runes
+// of text marked by highlight.
+func (d *differ) line(color, prefix, text string, highlight map[int]bool) {
+	color = d.paint(color)
+	d.WriteString(color + prefix)
+	for i, r := range []rune(text) {
+		if highlight[i] {
+			d.WriteString(d.paint(reverse) + string(r) + d.paint(noColor) + color)
+			continue
+		}
+		d.WriteRune(r)
+	}
+	if color != "" {
+		d.WriteString(noColor)
+	}
+	d.WriteString("\n")
+}
+
+// paint returns the escape sequence of a color if the output is colored.
+func (d *differ) paint(color string) string {
+	if !d.color {
+		return ""
+	}
+	return color
+}
+
+// grids writes the headers and grids of two patterns, marking the
+// lines and steps that changed.
+func (d *differ) grids(a, b *drum.Pattern, changes []drum.Change) {
+	oldLines, newLines := lines(a), lines(b)
+	oldHeader := oldLines[:len(oldLines)-len(a.Tracks)]
+	newHeader := newLines[:len(newLines)-len(b.Tracks)]
+	for _, prefix := range headerPrefixes {
+		old, new := headerLine(oldHeader, prefix), headerLine(newHeader, prefix)
+		switch {
+		case old == new && old != "":
+			d.line("", " ", new, nil)
+		case old != new:
+			if old != "" {
+				d.line(red, "-", old, nil)
+			}
+			if new != "" {
+				d.line(green, "+", new, nil)
+			}
+		}
+	}
+
+	// Collect the changes of each track, which are matched by ID.
+	removed, added := make(map[uint32]int), make(map[uint32]int)
+	renamed := make(map[uint32]bool)
+	steps := make(map[uint32]map[int]bool)
+	for _, c := range changes {
+		switch c.Kind {
+		case drum.ChangeTrackRemoved:
+			removed[c.Track]++
+		case drum.ChangeTrackAdded:
+			added[c.Track]++
+		case drum.ChangeTrackRenamed:
+			renamed[c.Track] = true
+		case drum.ChangeStep:
+			if steps[c.Track] == nil {
+				steps[c.Track] = make(map[int]bool)
+			}
+			steps[c.Track][c.Step] = true
+		}
+	}
+	// Removed and added tracks sharing an ID with matched tracks
+	// are the last ones with the ID.
+	count := func(tracks drum.Tracks) map[uint32]int {
+		n := make(map[uint32]int)
+		for _, t := range tracks {
+			n[t.ID]++
+		}
+		return n
+	}
+	oldCount, newCount := count(a.Tracks), count(b.Tracks)
+	oldSeen, newSeen := make(map[uint32]int), make(map[uint32]int)
+	type track struct {
+		line  string
+		steps int
+	}
+	oldTracks := make(map[uint32][]track)
+	for i, t := range a.Tracks {
+		l := oldLines[len(oldHeader)+i]
+		if oldSeen[t.ID]++; oldSeen[t.ID] > oldCount[t.ID]-removed[t.ID] {
+			d.line(red, "-", l, nil)
+			continue
+		}
+		oldTracks[t.ID] = append(oldTracks[t.ID], track{l, len(t.Sequence)})
+	}
+	for j, t := range b.Tracks {
+		l := newLines[len(newHeader)+j]
+		if newSeen[t.ID]++; newSeen[t.ID] > newCount[t.ID]-added[t.ID] {
+			d.line(green, "+", l, nil)
+			continue
+		}
+		old := oldTracks[t.ID][0]
+		oldTracks[t.ID] = oldTracks[t.ID][1:]
+		if old.line == l && !renamed[t.ID] && len(steps[t.ID]) == 0 {
+			d.line("", " ", l, nil)
+			continue
+		}
+		d.line(red, "-", old.line, gridRunes(old.line, old.steps, a.TimeSignature, steps[t.ID]))
+		d.line(green, "+", l, gridRunes(l, len(t.Sequence), b.TimeSignature, steps[t.ID]))
+	}
+}
+
+// gridRunes returns the indices of the runes of a track line showing
+// the given steps. The grid of n steps ends with the last '|' of the line.
+func gridRunes(line string, n int, sig drum.TimeSignature, steps map[int]bool) map[int]bool {
+	perBeat := sig.StepsPerBeat
+	if perBeat == 0 {
+		perBeat = drum.DefaultTimeSignature.StepsPerBeat
+	}
+	runes := []rune(line)
+	end := -1
+	for i, r := range runes {
+		if r == '|' {
+			end = i
+		}
+	}
+	start := end - n - n/perBeat
+	if end < 0 || start < 0 {
+		return nil
+	}
+	highlight := make(map[int]bool)
+	for i := 0; i < n; i++ {
+		if steps[i] {
+			highlight[start+1+i+i/perBeat] = true
+		}
+	}
+	return highlight
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"splice/encoding/drum"
+)
+
+var fmtCommand = &command{
+	name:    "fmt",
+	args:    "[path ...]",
+	summary: "Format text backups canonically",
+	setup: func(fs *flag.FlagSet) func(*env, []string) error {
+		f := &formatter{}
+		fs.BoolVar(&f.write, "w", false, "Write the result to the (source) file instead of standard output")
+		fs.BoolVar(&f.list, "l", false, "List files whose formatting differs from splice fmt's")
+		fs.BoolVar(&f.diff, "d", false, "Display diffs instead of rewriting files")
+		return f.run
+	},
+}
+
+// A formatter formats the text backups named by its arguments,
+// searching directories for .txt files.
+type formatter struct {
+	write, list, diff bool
+	failed            bool
+}
+
+func (f *formatter) run(e *env, args []string) error {
+	if len(args) == 0 {
+		if f.write || f.list {
+			return fmt.Errorf("cannot use -w or -l with standard input")
+		}
+		_, b, err := readInput(e, nil)
+		if err != nil {
+			return err
+		}
+		return f.format(e, "<standard input>", b)
+	}
+	for _, arg := range args {
+		// Files named by the arguments are formatted whatever their extension.
+		err := filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && (path == arg || filepath.Ext(path) == ".txt") {
+				err = f.formatFile(e, path)
+			}
+			if err != nil {
+				f.report(e, err)
+			}
+			return nil
+		})
+		if err != nil {
+			f.report(e, err)
+		}
+	}
+	if f.failed {
+		return exitStatus(2)
+	}
+	return nil
+}
+
+func (f *formatter) report(e *env, err error) {
+	fmt.Fprintf(e.stderr, "splice fmt: %v\n", err)
+	f.failed = true
+}
+
+func (f *formatter) formatFile(e *env, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return f.format(e, path, b)
+}
+
+func (f *formatter) format(e *env, path string, src []byte) error {
+	res, err := drum.Format(bytes.NewReader(src))
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if !f.list && !f.write && !f.diff {
+		_, err = e.stdout.Write(res)
+		return err
+	}
+	if bytes.Equal(src, res) {
+		return nil
+	}
+	if f.list {
+		fmt.Fprintln(e.stdout, path)
+	}
+	if f.write {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, res, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if f.diff {
+		d, err := diffText(src, res)
+		if err != nil {
+			return fmt.Errorf("computing diff: %v", err)
+		}
+		fmt.Fprintf(e.stdout, "diff %s splice/%s\n", path, path)
+		e.stdout.Write(d)
+	}
+	return nil
+}
+
+// diffText returns the unified diff of a and b computed by the diff command.
+func diffText(a, b []byte) ([]byte, error) {
+	var names []string
+	for _, data := range [][]byte{a, b} {
+		f, err := ioutil.TempFile("", "splice")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, f.Name())
+	}
+	out, err := exec.Command("diff", "-u", names[0], names[1]).CombinedOutput()
+	if len(out) > 0 {
+		// diff exits with status 1 when the files differ.
+		return out, nil
+	}
+	return out, err
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"splice/encoding/drum"
+	"strings"
+)
+
+var inspectCommand = &command{
+	name:    "inspect",
+	args:    "[file]",
+	summary: "Print the fields of a .splice file",
+	doc: "Inspect decodes a .splice file and prints every field of its chunks with its\n" +
+		"offset, length, bytes and decoded value. Bytes that are not part of any field\n" +
+		"are highlighted and reported, as are chunks whose fields end elsewhere than\n" +
+		"their declared length says and the error that stopped decoding.\n\n" +
+		"Inspect exits with status 1 if it reports any problem.",
+	setup: func(fs *flag.FlagSet) func(*env, []string) error {
+		color := fs.String("color", "auto", "Color the output: `auto`, always or never")
+		return func(e *env, args []string) error {
+			name, b, err := readInput(e, args)
+			if err != nil {
+				return err
+			}
+			l := decodeLayout(name, b)
+			in := inspector{color: colorOutput(e, *color)}
+			in.fields(l)
+			problems := in.problems(l)
+			if _, err := e.stdout.Write(in.Bytes()); err != nil {
+				return err
+			}
+			if problems > 0 {
+				return exitStatus(1)
+			}
+			return nil
+		}
+	},
+}
+
+const (
+	yellow  = "\x1b[33m"
+	blue    = "\x1b[34m"
+	magenta = "\x1b[35m"
+	cyan    = "\x1b[36m"
+)
+
+// trackColors tell apart the fields of neighboring tracks.
+var trackColors = []string{cyan, yellow, magenta, blue}
+
+// inspectWidth is the number of bytes shown on a line.
+const inspectWidth = 16
+
+// An inspector writes the fields of a file.
+type inspector struct {
+	bytes.Buffer
+	color bool
+}
+
+func (in *inspector) paint(color, s string) string {
+	if !in.color || color == "" {
+		return s
+	}
+	return color + s + noColor
+}
+
+// fields writes a line for every span of l, continued on further lines
+// for spans of more than inspectWidth bytes.
+func (in *inspector) fields(l *layout) {
+	width := len("field")
+	for _, s := range l.spans {
+		if n := len(s.String()); n > width {
+			width = n
+		}
+	}
+	fmt.Fprintf(in, "%s: %d bytes\n\n", l.name, len(l.data))
+	fmt.Fprintf(in, "%8s %6s  %-*s  %-*s  %s\n", "offset", "length", width, "field", 3*inspectWidth-1, "bytes", "value")
+	for _, s := range l.spans {
+		color := spanColor(s)
+		raw := l.data[s.Offset : s.Offset+s.Length]
+		value := formatValue(s)
+		for i := 0; i < len(raw); i += inspectWidth {
+			end := i + inspectWidth
+			if end > len(raw) {
+				end = len(raw)
+			}
+			line := fmt.Sprintf("%-*s", 3*inspectWidth-1, fmt.Sprintf("% x", raw[i:end]))
+			if i == 0 {
+				line = fmt.Sprintf("%8d %6d  %-*s  %s  %s", s.Offset, s.Length, width, s, line, value)
+			} else {
+				line = fmt.Sprintf("%8s %6s  %-*s  %s", "", "", width, "", line)
+			}
+			fmt.Fprintln(in, in.paint(color, strings.TrimRight(line, " ")))
+		}
+	}
+}
+
+// spanColor returns the color of a span: none for chunk headers,
+// green for pattern headers, alternating colors for tracks and
+// red for unmapped bytes.
+func spanColor(s span) string {
+	switch {
+	case s.Field == fieldUnmapped:
+		return red + reverse
+	case s.Track >= 0:
+		return trackColors[s.Track%len(trackColors)]
+	case s.Field == drum.FieldVersion || s.Field == drum.FieldTempo:
+		return green
+	case s.Field == drum.FieldExtension || s.Field == drum.FieldArrangement:
+		return magenta
+	}
+	return ""
+}
+
+// formatValue writes the decoded value of a span, drawing steps
+// like the grids of text backups.
+func formatValue(s span) string {
+	switch v := s.Value.(type) {
+	case nil:
+		return ""
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []byte:
+		if s.Field != drum.FieldSteps {
+			return fmt.Sprintf("%q", v)
+		}
+		grid := make([]byte, len(v))
+		for i, b := range v {
+			switch b {
+			case 0:
+				grid[i] = '-'
+			case 1:
+				grid[i] = 'x'
+			default:
+				grid[i] = '?'
+			}
+		}
+		return string(grid)
+	}
+	return fmt.Sprint(s.Value)
+}
+
+// problems writes the problems of a layout and returns their number:
+// unmapped bytes, chunks whose declared length disagrees with
+// their fields and the error that stopped decoding.
+func (in *inspector) problems(l *layout) int {
+	var problems []string
+	for i, s := range l.spans {
+		if s.Field == fieldUnmapped {
+			problems = append(problems, fmt.Sprintf("%d bytes at offset %d are not part of any field", s.Length, s.Offset))
+		}
+		if s.Field != drum.FieldChunkLength {
+			continue
+		}
+		length, ok := s.Value.(uint64)
+		if !ok {
+			continue
+		}
+		declared := s.Offset + s.Length + int64(length)
+		end := s.Offset + s.Length
+		for _, t := range l.spans[i+1:] {
+			if t.Chunk != s.Chunk {
+				break
+			}
+			end = t.Offset + t.Length
+		}
+		switch {
+		case declared > int64(len(l.data)):
+			problems = append(problems, fmt.Sprintf("chunk %d declares %d bytes, ending at offset %d past the end of the file, and its fields end at offset %d",
+				s.Chunk, length, declared, end))
+		case declared != end:
+			problems = append(problems, fmt.Sprintf("chunk %d declares %d bytes, ending at offset %d, but its fields end at offset %d",
+				s.Chunk, length, declared, end))
+		}
+	}
+	if l.err != nil {
+		problems = append(problems, "decoding stopped: "+l.err.Error())
+	}
+	if len(problems) > 0 {
+		fmt.Fprintln(in)
+	}
+	for _, p := range problems {
+		fmt.Fprintln(in, in.paint(bold+red, "! "+p))
+	}
+	return len(problems)
+}
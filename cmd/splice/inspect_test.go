@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestInspectFields(t *testing.T) {
+	b, err := ioutil.ReadFile(path.Join(fixtureDir, "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in inspector
+	in.fields(decodeLayout("pattern_1.splice", b))
+	lines := strings.Split(in.String(), "\n")
+	for _, expected := range []string{
+		"       6      8  chunk length         00 00 00 00 00 00 00 c5                          197",
+		"      14     32  hardware version     30 2e 38 30 38 2d 61 6c 70 68 61 00 00 00 00 00  \"0.808-alpha\"",
+		"                                      00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00",
+		"      46      4  tempo                00 00 f0 42                                      120",
+		"      59     16  track 0 steps        01 00 00 00 01 00 00 00 01 00 00 00 01 00 00 00  x---x---x---x---",
+	} {
+		found := false
+		for _, l := range lines {
+			found = found || l == expected
+		}
+		if !found {
+			t.Fatalf("Expected the line\n%v\nin:\n%v", expected, in.String())
+		}
+	}
+}
+
+func TestInspectProblems(t *testing.T) {
+	valid, err := ioutil.ReadFile(path.Join(fixtureDir, "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated, err := ioutil.ReadFile(path.Join(fixtureDir, "pattern_5.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Declaring 4 bytes fewer than the chunk holds ends it within the last track.
+	short := append([]byte{}, valid...)
+	short[13] -= 4
+	tData := []struct {
+		name     string
+		data     []byte
+		problems []string
+	}{
+		{"valid", valid, nil},
+		{"appended", append(valid[:len(valid):len(valid)], "abcd"...), []string{
+			"! 4 bytes at offset 211 are not part of any field",
+			"! decoding stopped: drum: truncated chunk: chunk 1 header at offset 211",
+		}},
+		{"short", short, []string{
+			"! chunk 0 declares 193 bytes, ending at offset 207, but its fields end at offset 195",
+			"! 16 bytes at offset 195 are not part of any field",
+			"! decoding stopped: drum: chunk length mismatch: chunk 0 track 5 steps at offset 195",
+		}},
+		{"truncated", truncated, []string{
+			"! chunk 1 declares 22689695841 bytes, ending at offset 22689695956 past the end of the file, and its fields end at offset 115",
+			"! 17 bytes at offset 115 are not part of any field",
+			"! decoding stopped: drum: truncated chunk: chunk 1 header at offset 115",
+		}},
+	}
+	for _, exp := range tData {
+		var in inspector
+		n := in.problems(decodeLayout(exp.name, exp.data))
+		var problems []string
+		for _, l := range strings.Split(in.String(), "\n") {
+			if strings.HasPrefix(l, "!") {
+				problems = append(problems, l)
+			}
+		}
+		if n != len(exp.problems) || strings.Join(problems, "\n") != strings.Join(exp.problems, "\n") {
+			t.Fatalf("Expected %v problems inspecting %v:\n%v\nReceived %v:\n%v", len(exp.problems), exp.name,
+				strings.Join(exp.problems, "\n"), n, strings.Join(problems, "\n"))
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"splice/encoding/drum"
+	"strings"
+)
+
+// readInput returns the contents of the file named by args, or of
+// standard input if args is empty or "-".
+func readInput(e *env, args []string) (name string, b []byte, err error) {
+	switch {
+	case len(args) > 1:
+		return "", nil, errUsage
+	case len(args) == 0 || args[0] == "-":
+		b, err = ioutil.ReadAll(e.stdin)
+		return "<standard input>", b, err
+	}
+	b, err = ioutil.ReadFile(args[0])
+	return args[0], b, err
+}
+
+// writeOutput writes b to the file at path, or to standard output
+// if path is empty or "-".
+func writeOutput(e *env, path string, b []byte) error {
+	if path == "" || path == "-" {
+		_, err := e.stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0666)
+}
+
+// isSplice reports whether b holds .splice chunks rather than
+// a text backup.
+func isSplice(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("SPL"))
+}
+
+// parsePattern decodes a pattern from a .splice file or a text backup.
+// Empty input, such as that of /dev/null, holds an empty pattern.
+func parsePattern(name string, b []byte) (*drum.Pattern, error) {
+	p := drum.NewPattern()
+	var err error
+	switch {
+	case len(bytes.TrimSpace(b)) == 0:
+		return p, nil
+	case isSplice(b):
+		err = drum.NewDecoder(bytes.NewReader(b)).Decode(p)
+	default:
+		p, err = drum.NewPatternFromBackup(string(b))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return p, nil
+}
+
+// readPattern reads a pattern from the file at path.
+func readPattern(path string) (*drum.Pattern, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePattern(path, b)
+}
+
+// encode returns the .splice encoding of p.
+func encode(p *drum.Pattern) ([]byte, error) {
+	b := new(bytes.Buffer)
+	err := drum.NewEncoder(b).Encode(*p)
+	return b.Bytes(), err
+}
+
+// lines returns the lines of the text backup of p,
+// or none if p is empty.
+func lines(p *drum.Pattern) []string {
+	if p.HardwareVersion == "" && p.Tempo == 0 && len(p.Tracks) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(p.String(), "\n"), "\n")
+}
+
+// Prefixes of the header lines of a text backup.
+var headerPrefixes = []string{"Saved with HW Version:", "Tempo:", "Steps:", "Swing:"}
+
+// headerLine returns the line of a text backup header starting
+// with prefix, or "" if there is none.
+func headerLine(lines []string, prefix string) string {
+	for _, l := range lines {
+		if strings.HasPrefix(l, prefix) {
+			return l
+		}
+	}
+	return ""
+}
+
+// colorOutput reports whether to color the output of a command
+// given the value of its -color flag: auto, always or never.
+// Auto colors output written to a terminal.
+func colorOutput(e *env, mode string) bool {
+	if f, ok := e.stdout.(*os.File); ok && mode == "auto" {
+		info, err := f.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+	return mode == "always"
+}
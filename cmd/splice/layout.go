@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"splice/encoding/drum"
+)
+
+// A layout is the structure of a .splice file: the fields a Decoder
+// decoded from it, followed by the bytes it could not decode, if any.
+type layout struct {
+	name    string
+	data    []byte
+	spans   []span
+	pattern *drum.Pattern // first pattern of the file, or nil
+	err     error         // error decoding the file, if any
+}
+
+// A span is a field of a file with the chunk and pattern it belongs to.
+type span struct {
+	drum.FieldSpan
+	chunk   string // identifier of the chunk, or "" for unmapped bytes
+	pattern int    // index of the pattern; extension chunks precede theirs
+}
+
+// fieldUnmapped names bytes that are not part of any decoded field.
+const fieldUnmapped drum.Field = "unmapped"
+
+// fieldOrder orders the fields of a chunk.
+var fieldOrder = []drum.Field{
+	drum.FieldChunkID,
+	drum.FieldChunkLength,
+	drum.FieldExtension,
+	drum.FieldVersion,
+	drum.FieldTempo,
+	drum.FieldTrackID,
+	drum.FieldNameLength,
+	drum.FieldName,
+	drum.FieldSteps,
+	drum.FieldArrangement,
+	fieldUnmapped,
+}
+
+// decodeLayout decodes the chunks of b up to the first error.
+func decodeLayout(name string, b []byte) *layout {
+	l := &layout{name: name, data: b}
+	d := drum.NewDecoder(bytes.NewReader(b))
+	d.MapFields = true
+	decoded := 0
+	for ; d.More(); decoded++ {
+		p := drum.NewPattern()
+		if l.err = d.Decode(p); l.err != nil {
+			break
+		}
+		if l.pattern == nil {
+			l.pattern = p
+		}
+	}
+	chunk, pattern := "", 0
+	var end int64
+	// The pattern of a span is counted by the pattern chunks preceding it.
+	for _, f := range d.Fields() {
+		if f.Field == drum.FieldChunkID {
+			if chunk == "SPLICE" {
+				pattern++
+			}
+			chunk = string(b[f.Offset : f.Offset+f.Length])
+		}
+		l.spans = append(l.spans, span{f, chunk, pattern})
+		end = f.Offset + f.Length
+	}
+	if end < int64(len(b)) {
+		// Unmapped bytes belong to the pattern that failed to decode,
+		// or follow the decoded patterns.
+		f := drum.FieldSpan{Offset: end, Length: int64(len(b)) - end, Field: fieldUnmapped, Track: -1, Chunk: -1}
+		l.spans = append(l.spans, span{f, "", decoded})
+	}
+	return l
+}
+
+// spanAt returns the span holding the byte at offset, if any.
+func (l *layout) spanAt(offset int64) (span, bool) {
+	for _, s := range l.spans {
+		if offset >= s.Offset && offset < s.Offset+s.Length {
+			return s, true
+		}
+	}
+	return span{}, false
+}
+
+func (s span) String() string {
+	if s.Track >= 0 {
+		return fmt.Sprintf("track %d %s", s.Track, s.Field)
+	}
+	return string(s.Field)
+}
+
+// A slot identifies a byte by its place in the decoded structure rather
+// than by its offset, so that files whose fields differ in length, such as
+// those with track names of different lengths, can be compared byte by byte.
+type slot struct {
+	pattern int
+	chunk   string
+	track   int
+	field   drum.Field
+	index   int // of the byte among the bytes of its field
+}
+
+// slots returns the slot of every byte of the layout, in order.
+func (l *layout) slots() []slot {
+	var slots []slot
+	next := make(map[slot]int)
+	for _, s := range l.spans {
+		// Fields read piecemeal, such as the records of an extension chunk,
+		// share one slot of consecutive bytes.
+		k := slot{s.pattern, s.chunk, s.Track, s.Field, 0}
+		for i := int64(0); i < s.Length; i++ {
+			slots = append(slots, slot{k.pattern, k.chunk, k.track, k.field, next[k]})
+			next[k]++
+		}
+	}
+	return slots
+}
+
+func (s slot) less(t slot) bool {
+	switch {
+	case s.pattern != t.pattern:
+		return s.pattern < t.pattern
+	case s.chunk != t.chunk:
+		return chunkOrder(s.chunk) < chunkOrder(t.chunk)
+	case s.track != t.track:
+		return s.track < t.track
+	case s.field != t.field:
+		return indexOf(s.field) < indexOf(t.field)
+	}
+	return s.index < t.index
+}
+
+func (s slot) String() string {
+	str := ""
+	if s.pattern > 0 {
+		str = fmt.Sprintf("pattern %d ", s.pattern)
+	}
+	if s.chunk != "" {
+		str += s.chunk + " "
+	}
+	if s.track >= 0 {
+		str += fmt.Sprintf("track %d ", s.track)
+	}
+	return fmt.Sprintf("%s%s[%d]", str, s.field, s.index)
+}
+
+// chunkOrder orders extension chunks before the pattern chunks they
+// precede, and unmapped bytes last.
+func chunkOrder(id string) int {
+	switch id {
+	case "SPLEXT":
+		return 0
+	case "SPLICE":
+		return 1
+	case "":
+		return 3
+	}
+	return 2
+}
+
+func indexOf(f drum.Field) int {
+	for i, g := range fieldOrder {
+		if f == g {
+			return i
+		}
+	}
+	return len(fieldOrder)
+}
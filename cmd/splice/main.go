@@ -0,0 +1,152 @@
+// Splice reads, writes and compares drum patterns stored as .splice files
+// or their text backups.
+//
+// Usage:
+//
+//	splice <command> [flags] [arguments]
+//
+// Run 'splice help' for the list of commands and 'splice help <command>'
+// for the flags and arguments of a command. Commands read from standard
+// input and write to standard output unless given paths.
+//
+// Splice exits with status 0 on success, 1 when diff finds differences or
+// merge finds conflicts, and 2 on errors and invalid usage.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A command is a subcommand of splice.
+type command struct {
+	name    string
+	args    string // arguments following the flags in usage messages
+	summary string
+	doc     string // further documentation following the summary, if any
+	// setup defines the flags of the command on fs and returns
+	// the function running the command with the remaining arguments.
+	setup func(fs *flag.FlagSet) func(e *env, args []string) error
+}
+
+// An env holds the standard streams of a command.
+type env struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+var commands = []*command{
+	decodeCommand,
+	encodeCommand,
+	fmtCommand,
+	diffCommand,
+	mergeCommand,
+	midiCommand,
+	importCommand,
+	renderCommand,
+	analyzeCommand,
+	inspectCommand,
+}
+
+// errUsage is returned by commands given invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+// An exitStatus is returned by commands to exit with a status
+// other than 0 without reporting an error.
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], &env{os.Stdin, os.Stdout, os.Stderr}))
+}
+
+// run runs the command named by args[0] and returns the exit status.
+func run(args []string, e *env) int {
+	if len(args) == 0 {
+		usage(e.stderr)
+		return 2
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		if len(args) == 1 {
+			usage(e.stdout)
+			return 0
+		}
+		c := lookup(args[1])
+		if c == nil {
+			fmt.Fprintf(e.stderr, "splice help: unknown command %q\n", args[1])
+			return 2
+		}
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		c.setup(fs)
+		fs.SetOutput(e.stdout)
+		c.usage(fs)
+		return 0
+	}
+	c := lookup(args[0])
+	if c == nil {
+		fmt.Fprintf(e.stderr, "splice: unknown command %q\nRun 'splice help' for usage.\n", args[0])
+		return 2
+	}
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	runCommand := c.setup(fs)
+	fs.Usage = func() { c.usage(fs) }
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	err := runCommand(e, fs.Args())
+	var status exitStatus
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &status):
+		return int(status)
+	case err == errUsage:
+		fs.Usage()
+		return 2
+	}
+	fmt.Fprintf(e.stderr, "splice %s: %v\n", c.name, err)
+	return 2
+}
+
+func lookup(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Splice reads, writes and compares drum patterns.")
+	fmt.Fprint(w, "\nUsage:\n\n\tsplice <command> [flags] [arguments]\n\nThe commands are:\n\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "\t%-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w, "\nUse 'splice help <command>' for more information about a command.")
+}
+
+func (c *command) usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "usage: splice %s [flags] %s\n\n%s.\n", c.name, c.args, c.summary)
+	if c.doc != "" {
+		fmt.Fprintf(w, "\n%s\n", c.doc)
+	}
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(w, "\nFlags:")
+		fs.PrintDefaults()
+	}
+}
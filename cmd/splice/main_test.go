@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"splice/encoding/drum"
+)
+
+var patterns = path.Join("..", "..", "encoding", "drum", "patterns")
+
+func readFixture(t *testing.T, name string) []byte {
+	b, err := ioutil.ReadFile(path.Join(patterns, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRun(t *testing.T) {
+	pattern1 := readFixture(t, "pattern_1.splice")
+	p, err := drum.DecodeFile(path.Join(patterns, "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := p.String()
+	encoded := new(bytes.Buffer)
+	if err := drum.NewEncoder(encoded).Encode(*p); err != nil {
+		t.Fatal(err)
+	}
+
+	tData := []struct {
+		args   []string
+		stdin  []byte
+		status int
+		stdout string // expected output, or a part of it if it starts with "..."
+		stderr string // part of the expected error output
+	}{
+		{nil, nil, 2, "", "Usage:"},
+		{[]string{"--help"}, nil, 0, "...The commands are:", ""},
+		{[]string{"help", "inspect"}, nil, 0, "...usage: splice inspect [flags] [file]", ""},
+		{[]string{"help", "play"}, nil, 2, "", `unknown command "play"`},
+		{[]string{"play"}, nil, 2, "", `unknown command "play"`},
+		{[]string{"decode", "-h"}, nil, 0, "", "usage: splice decode"},
+		{[]string{"decode", "-x"}, nil, 2, "", "usage: splice decode"},
+		{[]string{"decode", "a.splice", "b.splice"}, nil, 2, "", "usage: splice decode"},
+		{[]string{"decode", "missing.splice"}, nil, 2, "", "splice decode: open missing.splice"},
+		{[]string{"decode"}, pattern1, 0, backup, ""},
+		{[]string{"decode", "-"}, pattern1, 0, backup, ""},
+		{[]string{"decode"}, []byte("Tempo: fast\n"), 2, "", "line 1, column 8"},
+		{[]string{"encode"}, []byte(backup), 0, encoded.String(), ""},
+		{[]string{"fmt"}, []byte("Tempo:120\n"), 0, "Tempo: 120\n", ""},
+		{[]string{"diff", path.Join(patterns, "pattern_1.splice"), path.Join(patterns, "pattern_1.splice")}, nil, 0, "", ""},
+		{[]string{"diff", path.Join(patterns, "pattern_1.splice"), path.Join(patterns, "pattern_2.splice")}, nil, 1, "...Tempo", ""},
+		{[]string{"inspect", path.Join(patterns, "pattern_1.splice")}, nil, 0, "...track 5 name", ""},
+		{[]string{"inspect", path.Join(patterns, "pattern_5.splice")}, nil, 1, "...! 17 bytes at offset 115 are not part of any field", ""},
+		{[]string{"inspect"}, pattern1[:100], 1, "...! decoding stopped", ""},
+		{[]string{"inspect", "-color", "always"}, pattern1[:100], 1, "...\x1b[", ""},
+		{[]string{"analyze", patterns}, nil, 0, "...tempo             46-49  float32 little-endian", ""},
+		{[]string{"analyze", "-json", path.Join(patterns, "pattern_1.splice")}, nil, 0, "...\"files\"", ""},
+		{[]string{"analyze", path.Join(patterns, "missing")}, nil, 2, "", "splice analyze:"},
+	}
+	for _, input := range tData {
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		status := run(input.args, &env{bytes.NewReader(input.stdin), stdout, stderr})
+		if status != input.status {
+			t.Fatalf("Expected %v to exit with status %v but received %v:\n%s", input.args, input.status, status, stderr)
+		}
+		if part := strings.TrimPrefix(input.stdout, "..."); part != input.stdout {
+			if !strings.Contains(stdout.String(), part) {
+				t.Fatalf("Expected the output of %v to contain %q but received:\n%s", input.args, part, stdout)
+			}
+		} else if stdout.String() != input.stdout {
+			t.Fatalf("Expected the output of %v to be %q but received %q", input.args, input.stdout, stdout)
+		}
+		if !strings.Contains(stderr.String(), input.stderr) {
+			t.Fatalf("Expected the errors of %v to contain %q but received:\n%s", input.args, input.stderr, stderr)
+		}
+	}
+}
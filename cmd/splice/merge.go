@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"splice/encoding/drum"
+)
+
+var mergeCommand = &command{
+	name:    "merge",
+	args:    "base ours theirs",
+	summary: "Merge the changes of two patterns made since a common base",
+	doc: "Merge writes the result to the file of ours, in its format. The patterns may\n" +
+		"be .splice files or text backups. If the changes conflict, the text backup of\n" +
+		"the result is written instead, with each conflicting line between conflict\n" +
+		"markers, and merge exits with status 1.\n\n" +
+		"To merge .splice files in git, add '*.splice merge=splice' to .gitattributes\n" +
+		"and run\n\n" +
+		"\tgit config merge.splice.driver 'splice merge %O %A %B'",
+	setup: func(fs *flag.FlagSet) func(*env, []string) error {
+		return func(e *env, args []string) error {
+			if len(args) != 3 {
+				return errUsage
+			}
+			var patterns [3]*drum.Pattern
+			for i, path := range args {
+				p, err := readPattern(path)
+				if err != nil {
+					return err
+				}
+				patterns[i] = p
+			}
+			base, ours, theirs := patterns[0], patterns[1], patterns[2]
+			merged, conflicts := drum.Merge(base, ours, theirs)
+			oursPath := args[1]
+			if len(conflicts) == 0 {
+				return writeMerged(oursPath, merged)
+			}
+			for _, c := range conflicts {
+				fmt.Fprintf(e.stderr, "splice merge: %s: conflict: %v\n", oursPath, c)
+			}
+			// Resolving the conflicts in favor of theirs gives the other side
+			// of each conflict.
+			other, _ := drum.Merge(base, theirs, ours)
+			if err := ioutil.WriteFile(oursPath, markConflicts(merged, other, conflicts), 0666); err != nil {
+				return err
+			}
+			return exitStatus(1)
+		}
+	},
+}
+
+// writeMerged writes p to path, encoded if path holds a .splice file.
+func writeMerged(path string, p *drum.Pattern) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	out := []byte(p.String())
+	if isSplice(b) {
+		if out, err = encode(p); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path, out, 0666)
+}
+
+// markConflicts returns the text backup of ours, the result of a merge
+// resolving conflicts in favor of ours, with the lines of conflicts
+// replaced by the lines of ours and theirs between conflict markers.
+func markConflicts(ours, theirs *drum.Pattern, conflicts []drum.Conflict) []byte {
+	headers := make(map[string]bool)
+	tracks := make(map[uint32]bool)
+	for _, c := range conflicts {
+		switch c.Kind {
+		case drum.ChangeVersion:
+			headers["Saved with HW Version:"] = true
+		case drum.ChangeTempo:
+			headers["Tempo:"] = true
+		case drum.ChangeTimeSignature:
+			headers["Steps:"] = true
+		case drum.ChangeSwing:
+			headers["Swing:"] = true
+		default:
+			tracks[c.Track] = true
+		}
+	}
+	var b bytes.Buffer
+	block := func(ours, theirs []string) {
+		b.WriteString("<<<<<<< ours\n")
+		for _, l := range ours {
+			b.WriteString(l + "\n")
+		}
+		b.WriteString("=======\n")
+		for _, l := range theirs {
+			b.WriteString(l + "\n")
+		}
+		b.WriteString(">>>>>>> theirs\n")
+	}
+	oursLines, theirsLines := lines(ours), lines(theirs)
+	oursHeader := oursLines[:len(oursLines)-len(ours.Tracks)]
+	theirsHeader := theirsLines[:len(theirsLines)-len(theirs.Tracks)]
+	for _, prefix := range headerPrefixes {
+		o, t := headerLine(oursHeader, prefix), headerLine(theirsHeader, prefix)
+		switch {
+		case headers[prefix]:
+			block(nonEmpty(o), nonEmpty(t))
+		case o != "":
+			b.WriteString(o + "\n")
+		}
+	}
+	oursTracks := trackLines(ours, oursLines[len(oursHeader):])
+	theirsTracks := trackLines(theirs, theirsLines[len(theirsHeader):])
+	marked := make(map[uint32]bool)
+	for i, t := range ours.Tracks {
+		if !tracks[t.ID] {
+			b.WriteString(oursLines[len(oursHeader)+i] + "\n")
+			continue
+		}
+		if !marked[t.ID] {
+			block(oursTracks[t.ID], theirsTracks[t.ID])
+			marked[t.ID] = true
+		}
+	}
+	// Tracks ours removed follow.
+	for _, t := range theirs.Tracks {
+		if tracks[t.ID] && !marked[t.ID] {
+			block(nil, theirsTracks[t.ID])
+			marked[t.ID] = true
+		}
+	}
+	return b.Bytes()
+}
+
+// trackLines returns the lines of the tracks of p by ID.
+func trackLines(p *drum.Pattern, lines []string) map[uint32][]string {
+	byID := make(map[uint32][]string)
+	for i, t := range p.Tracks {
+		byID[t.ID] = append(byID[t.ID], lines[i])
+	}
+	return byID
+}
+
+// nonEmpty returns the line as the lines of a conflict,
+// or none if it is empty.
+func nonEmpty(line string) []string {
+	if line == "" {
+		return nil
+	}
+	return []string{line}
+}
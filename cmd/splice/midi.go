@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"splice/encoding/drum"
+	"splice/encoding/midi"
+)
+
+var midiCommand = &command{
+	name:    "midi",
+	args:    "[file]",
+	summary: "Convert a pattern to a Standard MIDI File",
+	setup: func(fs *flag.FlagSet) func(*env, []string) error {
+		out := fs.String("o", "", "Path of the MIDI (.mid) file to write instead of standard output")
+		format := fs.Int("format", 1, "MIDI file format, 0 or 1")
+		ppq := fs.Int("ppq", 96, "Ticks per quarter note")
+		steps := fs.Int("steps", 4, "Pattern steps per quarter note")
+		length := fs.Int("length", 0, "Note length in ticks, 0 for half a step")
+		loops := fs.Int("loops", 1, "Number of times the pattern is played")
+		return func(e *env, args []string) error {
+			name, b, err := readInput(e, args)
+			if err != nil {
+				return err
+			}
+			p, err := parsePattern(name, b)
+			if err != nil {
+				return err
+			}
+			var buf bytes.Buffer
+			enc := midi.NewEncoder(&buf)
+			enc.Format = *format
+			enc.PPQ = *ppq
+			enc.StepsPerQuarter = *steps
+			enc.NoteLength = *length
+			enc.Loops = *loops
+			if err := enc.Encode(*p); err != nil {
+				return err
+			}
+			return writeOutput(e, *out, buf.Bytes())
+		}
+	},
+}
+
+var importCommand = &command{
+	name:    "import",
+	args:    "[file.mid]",
+	summary: "Convert a Standard MIDI File to a .splice file",
+	setup: func(fs *flag.FlagSet) func(*env, []string) error {
+		out := fs.String("o", "", "Path of the .splice file to write instead of standard output")
+		version := fs.String("version", "0.808-alpha", "Hardware version to save the pattern with")
+		steps := fs.Int("steps", 4, "Pattern steps per quarter note")
+		tolerance := fs.Float64("tolerance", 0, "Fraction of a step a note may be off the grid without a warning")
+		return func(e *env, args []string) error {
+			_, b, err := readInput(e, args)
+			if err != nil {
+				return err
+			}
+			d := midi.NewDecoder(bytes.NewReader(b))
+			d.StepsPerQuarter = *steps
+			d.Tolerance = *tolerance
+			p := drum.NewPattern()
+			p.HardwareVersion = *version
+			misplaced, err := d.Decode(p)
+			if err != nil {
+				return err
+			}
+			for _, m := range misplaced {
+				fmt.Fprintf(e.stderr, "splice import: %v\n", m)
+			}
+			b, err = encode(p)
+			if err != nil {
+				return err
+			}
+			return writeOutput(e, *out, b)
+		}
+	},
+}
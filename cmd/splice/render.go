@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"splice/encoding/wav"
+	"splice/render"
+	"strconv"
+	"strings"
+)
+
+// assignments collects repeated key=value flags.
+type assignments map[string]string
+
+func (a assignments) String() string {
+	return fmt.Sprint(map[string]string(a))
+}
+
+func (a assignments) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("expected key=value but received %q", s)
+	}
+	a[kv[0]] = kv[1]
+	return nil
+}
+
+var renderCommand = &command{
+	name:    "render",
+	args:    "[file]",
+	summary: "Render a pattern to a WAVE file",
+	setup: func(fs *flag.FlagSet) func(*env, []string) error {
+		out := fs.String("o", "", "Path of the WAVE (.wav) file to write instead of standard output")
+		loops := fs.Int("loops", 1, "Number of times the pattern is played")
+		bits := fs.Int("bits", 16, "Bits per sample, 16 or 24")
+		rate := fs.Int("rate", 44100, "Sample rate in Hz")
+		samples, levels := make(assignments), make(assignments)
+		fs.Var(samples, "sample", "Sample for a track as `id=path.wav` or name=path.wav, repeatable")
+		fs.Var(levels, "level", "Gain and pan of a track as `id=gain,pan`, repeatable")
+		return func(e *env, args []string) error {
+			name, b, err := readInput(e, args)
+			if err != nil {
+				return err
+			}
+			p, err := parsePattern(name, b)
+			if err != nil {
+				return err
+			}
+			r := render.NewRenderer()
+			r.SampleRate = *rate
+			r.Loops = *loops
+			for track, path := range samples {
+				sample, err := r.LoadSample(path)
+				if err != nil {
+					return err
+				}
+				if id, err := strconv.ParseUint(track, 10, 32); err == nil {
+					r.Samples[uint32(id)] = sample
+				} else {
+					r.NamedSamples[track] = sample
+				}
+			}
+			for track, level := range levels {
+				id, err := strconv.ParseUint(track, 10, 32)
+				if err != nil {
+					return fmt.Errorf("invalid track ID %q", track)
+				}
+				var l render.Level
+				if _, err := fmt.Sscanf(level, "%g,%g", &l.Gain, &l.Pan); err != nil {
+					return fmt.Errorf("invalid level %q - %v", level, err)
+				}
+				r.Levels[uint32(id)] = l
+			}
+			a, err := r.Render(*p)
+			if err != nil {
+				return err
+			}
+			var buf bytes.Buffer
+			if err := wav.Encode(&buf, a, *bits); err != nil {
+				return err
+			}
+			return writeOutput(e, *out, buf.Bytes())
+		}
+	},
+}
@@ -0,0 +1,474 @@
+package drum
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The text backup format written by Pattern.String and read by
+// NewPatternFromBackup is a series of lines following this grammar,
+// where tokens may be separated by spaces and tabs:
+//
+//	backup     = { line "\n" } .
+//	line       = [ version | tempo | signature | swing | track ] [ comment ] .
+//	version    = "Saved with HW Version:" text .
+//	tempo      = "Tempo:" number .
+//	signature  = "Steps:" int "per beat," int "beats per bar," int ( "bars" | "bar" ) .
+//	swing      = "Swing:" int "%" [ "8th" | "16th" ] .
+//	track      = "(" int ")" name grid { annotation } .
+//	grid       = "|" beats "|" { beats "|" } .
+//	beats      = beat { beat } .
+//	beat       = "x" | "X" | "o" | "-" .
+//	annotation = int ":" detail { "," detail } .
+//	detail     = ( "v" | "p" | "r" ) int | "t" [ "+" | "-" ] int .
+//	comment    = "#" text .
+//
+// The version is the rest of its line, so it cannot be followed by
+// a comment, and it cannot hold NUL bytes. A name is the text up to the
+// grid, which cannot hold a "|". Leading and trailing spaces are trimmed
+// from both.
+//
+// The version, tempo, signature and swing may each be given once, in any
+// order, before the first track. The tempo must be positive. The grid of
+// every track has a group of beats for each beat of the time signature,
+// with a beat for every step. Annotations hold the details of hits at the
+// given step indices, as described by Track.Step.
+//
+// The text format of songs read by NewSongFromBackup follows this grammar,
+// where backups end at the next blank line or heading:
+//
+//	song        = { heading "\n" backup } arrangement .
+//	heading     = "Pattern" int .
+//	arrangement = "Arrangement" "\n" { section "\n" } .
+//	section     = int { "x" int | "tempo" number | ( "mute" | "solo" ) ids } .
+//	ids         = int { "," int } .
+//
+// Patterns are numbered in order from 1, and sections refer to them
+// by number. Blank lines may separate any two lines.
+
+const versionPrefix = "Saved with HW Version:"
+
+// NewPatternFromBackup creates a pattern structure by parsing
+// a backup file's human-readible text data.
+// It returns a *ParseError if the text does not follow the backup format.
+func NewPatternFromBackup(s string) (*Pattern, error) {
+	p := NewPattern()
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(s, "\n") {
+		sc := &lineScanner{text: strings.TrimSuffix(line, "\r"), line: i + 1}
+		sc.skipBlanks()
+		if sc.done() || sc.peek() == '#' {
+			continue
+		}
+		if _, err := p.parseLine(sc, seen); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// parseLine parses a header or track line into p and returns the line
+// as Pattern.String writes it, without any comment.
+// Seen records the headers parsed so far.
+func (p *Pattern) parseLine(sc *lineScanner, seen map[string]bool) (string, error) {
+	start := sc.pos
+	header := func(name string) error {
+		if seen[name] {
+			return sc.errorAt(start, "%s is repeated", name)
+		}
+		if len(p.Tracks) > 0 {
+			return sc.errorAt(start, "%s follows tracks", name)
+		}
+		seen[name] = true
+		sc.skipBlanks()
+		return nil
+	}
+	var line string
+	switch {
+	case sc.literal(versionPrefix):
+		if err := header("version"); err != nil {
+			return "", err
+		}
+		start := sc.pos
+		p.HardwareVersion = strings.TrimRight(sc.rest(), " \t")
+		if i := strings.IndexByte(p.HardwareVersion, 0); i >= 0 {
+			// NUL ends the version in the binary format.
+			return "", sc.errorAt(start+i, "hardware version holds a NUL byte")
+		}
+		return p.versionLine(), nil
+	case sc.literal("Tempo:"):
+		if err := header("tempo"); err != nil {
+			return "", err
+		}
+		var err error
+		if p.Tempo, err = sc.tempo(); err != nil {
+			return "", err
+		}
+		line = p.tempoLine()
+	case sc.literal("Steps:"):
+		if err := header("time signature"); err != nil {
+			return "", err
+		}
+		var err error
+		if p.TimeSignature, err = sc.timeSignature(); err != nil {
+			return "", err
+		}
+		line = p.signatureLine()
+	case sc.literal("Swing:"):
+		if err := header("swing"); err != nil {
+			return "", err
+		}
+		var err error
+		if p.Swing, err = sc.swing(); err != nil {
+			return "", err
+		}
+		line = p.swingLine()
+	case sc.peek() == '(':
+		sig := p.TimeSignature.orDefault()
+		t, err := sc.track(sig)
+		if err != nil {
+			return "", err
+		}
+		p.Tracks = append(p.Tracks, t)
+		line = t.format(sig.StepsPerBeat)
+	default:
+		return "", sc.errorf("expected a header or a track")
+	}
+	return line, sc.end()
+}
+
+// A lineScanner splits a line of a backup into tokens.
+type lineScanner struct {
+	text string
+	line int // line number counted from 1
+	pos  int // byte offset of the next token
+}
+
+// errorAt returns a *ParseError at byte offset pos of the line.
+func (s *lineScanner) errorAt(pos int, format string, args ...interface{}) error {
+	return &ParseError{
+		Line:   s.line,
+		Column: utf8.RuneCountInString(s.text[:pos]) + 1,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+// errorf returns a *ParseError at the next token.
+func (s *lineScanner) errorf(format string, args ...interface{}) error {
+	return s.errorAt(s.pos, format, args...)
+}
+
+func (s *lineScanner) done() bool {
+	return s.pos >= len(s.text)
+}
+
+// peek returns the next byte, or 0 at the end of the line.
+func (s *lineScanner) peek() byte {
+	if s.done() {
+		return 0
+	}
+	return s.text[s.pos]
+}
+
+// found describes the next token for error messages.
+func (s *lineScanner) found() string {
+	if s.done() {
+		return "end of line"
+	}
+	r, _ := utf8.DecodeRuneInString(s.text[s.pos:])
+	return strconv.QuoteRune(r)
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+func (s *lineScanner) skipBlanks() {
+	for !s.done() && isBlank(s.text[s.pos]) {
+		s.pos++
+	}
+}
+
+// literal consumes lit if it comes next, matching each space in lit
+// to one or more blanks.
+func (s *lineScanner) literal(lit string) bool {
+	pos := s.pos
+	for i := 0; i < len(lit); i++ {
+		if lit[i] == ' ' {
+			if pos >= len(s.text) || !isBlank(s.text[pos]) {
+				return false
+			}
+			for pos < len(s.text) && isBlank(s.text[pos]) {
+				pos++
+			}
+			continue
+		}
+		if pos >= len(s.text) || s.text[pos] != lit[i] {
+			return false
+		}
+		pos++
+	}
+	s.pos = pos
+	return true
+}
+
+// expect consumes lit, skipping blanks before it.
+func (s *lineScanner) expect(lit string) error {
+	s.skipBlanks()
+	if !s.literal(lit) {
+		return s.errorf("expected %q but found %s", lit, s.found())
+	}
+	return nil
+}
+
+// end reports an error unless only blanks and a comment remain.
+func (s *lineScanner) end() error {
+	s.skipBlanks()
+	if !s.done() && s.peek() != '#' {
+		return s.errorf("unexpected %s", s.found())
+	}
+	return nil
+}
+
+func (s *lineScanner) rest() string {
+	r := s.text[s.pos:]
+	s.pos = len(s.text)
+	return r
+}
+
+// span consumes the bytes satisfying ok and returns them.
+func (s *lineScanner) span(ok func(c byte) bool) string {
+	start := s.pos
+	for !s.done() && ok(s.text[s.pos]) {
+		s.pos++
+	}
+	return s.text[start:s.pos]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// uint consumes an unsigned integer of at most bits bits,
+// skipping blanks before it.
+func (s *lineScanner) uint(what string, bits int) (uint64, error) {
+	s.skipBlanks()
+	start := s.pos
+	digits := s.span(isDigit)
+	if digits == "" {
+		return 0, s.errorf("expected %s but found %s", what, s.found())
+	}
+	n, err := strconv.ParseUint(digits, 10, bits)
+	if err != nil {
+		return 0, s.errorAt(start, "%s %s is out of range", what, digits)
+	}
+	return n, nil
+}
+
+func (s *lineScanner) int(what string) (int, error) {
+	n, err := s.uint(what, 16)
+	return int(n), err
+}
+
+func (s *lineScanner) tempo() (float32, error) {
+	start := s.pos
+	number := s.span(func(c byte) bool {
+		return isDigit(c) || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-'
+	})
+	if number == "" {
+		return 0, s.errorf("expected a tempo but found %s", s.found())
+	}
+	tempo, err := strconv.ParseFloat(number, 32)
+	if err != nil {
+		return 0, s.errorAt(start, "malformed tempo %q", number)
+	}
+	if tempo <= 0 {
+		return 0, s.errorAt(start, "tempo %s is not positive", number)
+	}
+	return float32(tempo), nil
+}
+
+func (s *lineScanner) timeSignature() (TimeSignature, error) {
+	var sig TimeSignature
+	start := s.pos
+	fields := []struct {
+		n    *int
+		what string
+		lit  string
+	}{
+		{&sig.StepsPerBeat, "steps per beat", "per beat,"},
+		{&sig.BeatsPerBar, "beats per bar", "beats per bar,"},
+		{&sig.Bars, "bars", "bar"},
+	}
+	for _, f := range fields {
+		var err error
+		if *f.n, err = s.int(f.what); err != nil {
+			return sig, err
+		}
+		if err := s.expect(f.lit); err != nil {
+			return sig, err
+		}
+	}
+	s.literal("s")
+	if err := sig.validate(); err != nil {
+		return sig, s.errorAt(start, "%s", strings.TrimPrefix(err.Error(), "drum: "))
+	}
+	return sig, nil
+}
+
+func (s *lineScanner) swing() (Swing, error) {
+	var sw Swing
+	start := s.pos
+	var err error
+	if sw.Amount, err = s.int("swing amount"); err != nil {
+		return sw, err
+	}
+	if err := s.expect("%"); err != nil {
+		return sw, err
+	}
+	s.skipBlanks()
+	switch {
+	case s.literal("8th"):
+		sw.Resolution = 8
+	case s.literal("16th"):
+		sw.Resolution = 16
+	}
+	if err := sw.validate(); err != nil {
+		return sw, s.errorAt(start, "%s", strings.TrimPrefix(err.Error(), "drum: "))
+	}
+	return sw, nil
+}
+
+func (s *lineScanner) track(sig TimeSignature) (Track, error) {
+	var t Track
+	if err := s.expect("("); err != nil {
+		return t, err
+	}
+	id, err := s.uint("track ID", 32)
+	if err != nil {
+		return t, err
+	}
+	t.ID = uint32(id)
+	if err := s.expect(")"); err != nil {
+		return t, err
+	}
+	start := s.pos
+	name := s.span(func(c byte) bool { return c != byte(separator) })
+	t.Name = strings.TrimSpace(name)
+	if len(t.Name) > maxNameLen {
+		return t, s.errorAt(start, "track name exceeds %d bytes", maxNameLen)
+	}
+	steps, err := s.grid(sig)
+	if err != nil {
+		return t, err
+	}
+	for s.skipBlanks(); !s.done() && s.peek() != '#'; s.skipBlanks() {
+		if err := s.annotation(steps); err != nil {
+			return t, err
+		}
+	}
+	t.setSteps(steps)
+	return t, nil
+}
+
+func isBeat(c byte) bool {
+	switch rune(c) {
+	case onBeat, accentBeat, ghostBeat, offBeat:
+		return true
+	}
+	return false
+}
+
+// grid consumes a track's beats for every beat of the time signature.
+func (s *lineScanner) grid(sig TimeSignature) ([]Step, error) {
+	if s.done() {
+		return nil, s.errorf("expected %q after the track name", separator)
+	}
+	s.pos++
+	beats := sig.BeatsPerBar * sig.Bars
+	steps := make([]Step, 0, sig.Steps())
+	for i := 0; i < beats; i++ {
+		start := s.pos
+		for ; !s.done() && s.peek() != byte(separator); s.pos++ {
+			c := s.peek()
+			if !isBeat(c) {
+				return nil, s.errorf("expected a step of beat %d but found %s", i+1, s.found())
+			}
+			steps = append(steps, runeStep(rune(c)))
+			if c == byte(offBeat) {
+				steps[len(steps)-1] = Step{}
+			}
+		}
+		if n := len(steps) - i*sig.StepsPerBeat; n != sig.StepsPerBeat {
+			return nil, s.errorAt(start, "beat %d has %d steps instead of %d", i+1, n, sig.StepsPerBeat)
+		}
+		if s.done() {
+			return nil, s.errorf("expected %q after beat %d", separator, i+1)
+		}
+		s.pos++
+	}
+	if isBeat(s.peek()) {
+		return nil, s.errorf("expected %d beats of %d steps", beats, sig.StepsPerBeat)
+	}
+	return steps, nil
+}
+
+// annotation consumes the details of a hit in steps.
+func (s *lineScanner) annotation(steps []Step) error {
+	start := s.pos
+	i, err := s.uint("step index", 16)
+	if err != nil {
+		return err
+	}
+	if int(i) >= len(steps) {
+		return s.errorAt(start, "step %d is out of range", i)
+	}
+	if steps[i] == (Step{}) {
+		return s.errorAt(start, "step %d is not a hit", i)
+	}
+	if !s.literal(":") {
+		return s.errorf("expected %q but found %s", ":", s.found())
+	}
+	for {
+		field := s.pos
+		if s.done() {
+			return s.errorf("expected a step detail but found end of line")
+		}
+		c := s.peek()
+		lo, hi := int64(0), int64(255)
+		if c == 't' {
+			lo, hi = -128, 127
+		}
+		s.pos++
+		sign := s.span(func(c byte) bool { return c == '+' || c == '-' })
+		digits := s.span(isDigit)
+		n, err := strconv.ParseInt(sign+digits, 10, 16)
+		if err != nil || (sign != "" && c != 't') {
+			return s.errorAt(field, "malformed step detail %q", s.text[field:s.pos])
+		}
+		if n < lo || n > hi {
+			return s.errorAt(field, "step detail %q is out of range", s.text[field:s.pos])
+		}
+		switch c {
+		case 'v':
+			steps[i].Velocity = uint8(n)
+		case 'p':
+			steps[i].Probability = uint8(n)
+		case 't':
+			steps[i].Offset = int8(n)
+		case 'r':
+			steps[i].Ratchets = uint8(n)
+		default:
+			return s.errorAt(field, "unknown step detail %q", c)
+		}
+		if !s.literal(",") {
+			break
+		}
+	}
+	if err := steps[i].validate(); err != nil {
+		return s.errorAt(start, "%s", strings.TrimPrefix(err.Error(), "drum: "))
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package drum
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestParseCommentsAndOrder(t *testing.T) {
+	backup := `# A pattern with the header out of order.
+
+Tempo: 98.5   # beats per minute
+Swing: 58%
+Steps: 2 per beat, 2 beats per bar, 1 bar
+Saved with HW Version: S-1 # not a comment
+
+	# Tracks follow.
+(0) kick	|x-|X-|	# four on the floor
+(1) Low Conga |-o|-x|  3:p50
+`
+	p, err := NewPatternFromBackup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Saved with HW Version: S-1 # not a comment
+Tempo: 98.5
+Steps: 2 per beat, 2 beats per bar, 1 bars
+Swing: 58%
+(0) kick	|x-|X-|
+(1) Low Conga	|-o|-x|	3:p50
+`
+	if p.String() != expected {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", expected, p)
+	}
+	crlf, err := NewPatternFromBackup(strings.Replace(expected, "\n", "\r\n", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crlf.String() != expected {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", expected, crlf)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tData := []struct {
+		backup       string
+		line, column int
+	}{
+		{"Saved with HW Version: 0.8\x00", 1, 27},
+		{"Tempo: fast", 1, 8},
+		{"Tempo: 1.2.3", 1, 8},
+		{"Tempo: 120 bpm", 1, 12},
+		{"Tempo: -120", 1, 8},
+		{"Tempo:  0", 1, 9},
+		{"Tempo: 120\nTempo: 99", 2, 1},
+		{"Steps: 4 per beat, 4 beats bar, 1 bars", 1, 22},
+		{"Steps: 4 per beat, 0 beats per bar, 1 bars", 1, 8},
+		{"Steps: 99999 per beat, 4 beats per bar, 1 bars", 1, 8},
+		{"Swing: 62", 1, 10},
+		{"Swing: 62% 32nd", 1, 12},
+		{"(0) kick\t|x---|x---|x---|x---|\nTempo: 120", 2, 1},
+		{"(0) kick\t|x---|x---|x---|x---|\nSwing: 60%", 2, 1},
+		{"kick\t|x---|x---|x---|x---|", 1, 1},
+		{"(x) kick\t|x---|x---|x---|x---|", 1, 2},
+		{"(0 kick\t|x---|x---|x---|x---|", 1, 4},
+		{"(0) kick", 1, 9},
+		{"(0) kick\t|x---|x-y-|x---|x---|", 1, 18},
+		{"(0) kick\t|x---|x--|x---|x---|", 1, 16},
+		{"(0) kick\t|x---|x---|x---|x---", 1, 30},
+		{"(0) kick\t|x---|x---|x---|x---|x", 1, 31},
+		{"(0) kick\t|x---|x---|x---|x---|\t1:v90", 1, 32},
+		{"(0) kick\t|x---|x---|x---|x---|\t0:v90,q1", 1, 38},
+		{"(0) kick\t|x---|x---|x---|x---|\t0:v300", 1, 34},
+		{"(0) kick\t|x---|x---|x---|x---|\t0:v0", 1, 32},
+		{"(0) kïck\t|x---|x-y-|x---|x---|", 1, 18},
+	}
+	for _, input := range tData {
+		_, err := NewPatternFromBackup(input.backup)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("Expected a *ParseError parsing '%v' but received %v", input.backup, err)
+		}
+		if perr.Line != input.line || perr.Column != input.column {
+			t.Fatalf("Expected an error at line %v, column %v parsing '%v' but received %v",
+				input.line, input.column, input.backup, err)
+		}
+	}
+}
+
+func TestParseSongErrorLine(t *testing.T) {
+	const pattern = "Saved with HW Version: 0.808-alpha\nTempo: 120\n(0) kick\t|x---|x---|x---|x---|\n\n"
+	tData := []struct {
+		song         string
+		line, column int
+	}{
+		{"Pattern 1\nSaved with HW Version: 0.808-alpha\nTempo: 120\n(0) kick\t|x---|\n\nArrangement\n1\n", 4, 16},
+		{"Pattern 2\n" + pattern + "Arrangement\n1\n", 1, 9},
+		{"Pattern one\n" + pattern + "Arrangement\n1\n", 1, 9},
+		{"Pattern 1 of 2\n" + pattern + "Arrangement\n1\n", 1, 11},
+		{"Tempo: 120\n\nArrangement\n", 1, 1},
+		{"Pattern 1\n" + pattern, 6, 1},
+		{"Pattern 1\n" + pattern + "Arrangement\nfirst\n", 7, 1},
+		{"Pattern 1\n" + pattern + "Arrangement\n1 x\n", 7, 4},
+		{"Pattern 1\n" + pattern + "Arrangement\n1 tempo -5\n", 7, 9},
+		{"Pattern 1\n" + pattern + "Arrangement\n1 mute 1,x\n", 7, 10},
+		{"Pattern 1\n" + pattern + "Arrangement\n1\n1 loud 3\n", 8, 3},
+	}
+	for _, input := range tData {
+		_, err := NewSongFromBackup(input.song)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("Expected a *ParseError parsing:\n%v\nbut received %v", input.song, err)
+		}
+		if perr.Line != input.line || perr.Column != input.column {
+			t.Fatalf("Expected an error at line %v, column %v parsing:\n%v\nbut received %v",
+				input.line, input.column, input.song, err)
+		}
+	}
+}
+
+func TestParseNoPanic(t *testing.T) {
+	for _, name := range []string{"pattern_1.splice", "pattern_2.splice", "pattern_3.splice",
+		"pattern_4.splice", "pattern_5.splice"} {
+		p, err := DecodeFile(path.Join("patterns", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Tracks[0].Steps = make([]Step, len(p.Tracks[0].Sequence))
+		for i, v := range p.Tracks[0].Sequence {
+			if v == 1 {
+				p.Tracks[0].Steps[i] = Step{Velocity: 90, Probability: 50, Offset: -3, Ratchets: 2}
+			}
+		}
+		backup := p.String()
+		// Every truncation, deletion and replacement of a byte
+		// must parse or fail without panicking.
+		for i := 0; i < len(backup); i++ {
+			NewPatternFromBackup(backup[:i])
+			NewPatternFromBackup(backup[:i] + backup[i+1:])
+			for _, c := range "\n|:,-+#(9x\xff" {
+				NewPatternFromBackup(backup[:i] + string(c) + backup[i+1:])
+			}
+		}
+	}
+}
+
+func FuzzNewPatternFromBackup(f *testing.F) {
+	f.Add("Saved with HW Version: 0.808-alpha\nTempo: 120\n(0) kick\t|x---|X---|o---|x---|\t0:v90,t-12,r3\n")
+	f.Add("Steps: 3 per beat, 2 beats per bar, 1 bar\nSwing: 62% 8th\n(1) Low Conga |x-o|-X-|\n")
+	f.Fuzz(func(t *testing.T, backup string) {
+		p, err := NewPatternFromBackup(backup)
+		if err != nil {
+			return
+		}
+		// Parsing must be stable across a round trip,
+		// except for a version that does not fit on one line.
+		if strings.ContainsAny(p.HardwareVersion, "\r\n") {
+			return
+		}
+		// A missing tempo is written as 0, which does not parse.
+		if p.Tempo != 0 {
+			reparsed, err := NewPatternFromBackup(p.String())
+			if err != nil {
+				t.Fatalf("Could not reparse:\n%v\n%v", p, err)
+			}
+			if reparsed.String() != p.String() {
+				t.Fatalf("Expected:\n%v\nReceived:\n%v", p, reparsed)
+			}
+		}
+		formatted, err := Format(strings.NewReader(backup))
+		if err != nil {
+			t.Fatalf("Could not format:\n%v\n%v", backup, err)
+		}
+		again, err := Format(bytes.NewReader(formatted))
+		if err != nil || !bytes.Equal(again, formatted) {
+			t.Fatalf("Expected formatting to be idempotent:\n%s\nReceived:\n%s", formatted, again)
+		}
+	})
+}
@@ -1,16 +1,21 @@
 package drum
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"iter"
 	"os"
+	"reflect"
 )
 
 // DecodeFile decodes the drum machine file found at the provided path
 // and returns a pointer to a parsed pattern which is the entry point to the
-// rest of the data.
+// rest of the data. Only the first chunk of the file is decoded,
+// any bytes following it are kept in the pattern's Trailing field.
 func DecodeFile(path string) (*Pattern, error) {
 	p := NewPattern()
 	f, err := os.Open(path)
@@ -19,65 +24,313 @@ func DecodeFile(path string) (*Pattern, error) {
 	}
 	defer f.Close()
 	d := NewDecoder(f)
-	err = d.Decode(p)
+	if err := d.Decode(p); err != nil {
+		return p, err
+	}
+	p.Trailing, err = ioutil.ReadAll(d.buf)
+	if len(p.Trailing) == 0 {
+		p.Trailing = nil
+	}
 	return p, err
 }
 
 // A Decoder represents a drum pattern parser.
 // The parser assumes that input follows an undocumented specification.
+//
+// The input is a series of chunks, each holding one pattern.
+// A chunk opens with the "SPLICE" identifier followed by the big-endian
+// length of the rest of the chunk, which is the pattern header and tracks.
+// A pattern chunk may be preceded by an extension chunk.
 type Decoder struct {
 	// TODO(aoeu): Provide a specfication and better documentation.
-	r io.Reader
+
+	// MapFields makes the decoder record the span of every field
+	// it decodes, which Fields returns.
+	MapFields bool
+
+	buf      *bufio.Reader
+	r        *countingReader
+	chunk    int                 // index of the chunk being decoded
+	payload  *io.LimitedReader   // unread remainder of the chunk being decoded
+	steps    map[int]stepDetails // step details of tracks yet to be decoded
+	track    int                 // index of the next track of the chunk, or -1
+	perTrack int                 // steps of the tracks of the chunk
+	fields   []FieldSpan
+}
+
+// A FieldSpan locates a field a Decoder decoded in its input stream.
+type FieldSpan struct {
+	Offset int64 // byte offset in the input stream
+	Length int64
+	Field  Field
+	Track  int // index of the track within the chunk, or -1
+	Chunk  int // index of the chunk within the input stream
+	Raw    []byte
+	// Value is the decoded value of the field: a string for FieldChunkID,
+	// FieldVersion without its padding, a float32 for FieldTempo and the
+	// integer or byte slice the field holds otherwise.
+	Value interface{}
+}
+
+// Fields returns the spans of the fields decoded since MapFields was set,
+// in the order they were decoded.
+func (d *Decoder) Fields() []FieldSpan {
+	return d.fields
+}
+
+// mark records the span of a field starting at offset if the decoder maps fields.
+func (d *Decoder) mark(offset int64, f Field, track int, raw []byte, value interface{}) {
+	if d.MapFields {
+		d.fields = append(d.fields, FieldSpan{
+			Offset: offset,
+			Length: int64(len(raw)),
+			Field:  f,
+			Track:  track,
+			Chunk:  d.chunk,
+			Raw:    raw,
+			Value:  value,
+		})
+	}
 }
 
 // NewDecoder creates a new drum pattern decoder reading from r.
+//
+// The decoder introduces its own buffering and may read
+// data from r beyond the chunks requested.
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r}
+	buf := bufio.NewReader(r)
+	return &Decoder{buf: buf, r: &countingReader{r: buf}, chunk: -1, track: -1}
 }
 
-// Decode reads from the decoder's input stream to initialize a drum pattern.
+// More reports whether there is another chunk in the input stream.
+func (d *Decoder) More() bool {
+	_, err := d.buf.Peek(1)
+	return err == nil
+}
+
+// Decode reads the next chunk from the decoder's input stream
+// to initialize a drum pattern.
+// It returns io.EOF if the input stream holds no more chunks
+// and a *SyntaxError if the chunk is malformed.
 func (d *Decoder) Decode(p *Pattern) error {
-	h := header{}
-	if err := binary.Read(d.r, binary.LittleEndian, &h); err != nil {
+	if err := d.header(p); err != nil {
 		return err
 	}
-	p.HardwareVersion = string(bytes.Trim(h.HardwareVersion[:], "\x00"))
-	switch p.HardwareVersion {
-	case "0.808-alpha":
-		p.Tempo = int(h.Tempo) / 2
-		if h.TempoDecimal != 0 {
-			// TODO(aoeu): Is this really the correct way to determine the decimal?
-			p.TempoDecimal = int(h.TempoDecimal) - 200
+	p.Tracks = nil
+	for {
+		t, err := d.NextTrack()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p.Tracks = append(p.Tracks, t)
+	}
+}
+
+// Header reads the header of the next chunk from the decoder's input stream,
+// and of the extension chunk preceding it, if any, and returns a pattern
+// holding its settings without any tracks. The tracks of the chunk are
+// read by NextTrack or Tracks, one at a time; any left unread are skipped
+// by the next call to Header or Decode.
+// Header returns errors like Decode.
+func (d *Decoder) Header() (*Pattern, error) {
+	p := NewPattern()
+	if err := d.header(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NextTrack decodes the next track of the chunk whose header was read by
+// Header. It returns io.EOF after the last track of the chunk, and a
+// *SyntaxError if the track is malformed, after which it returns io.EOF.
+func (d *Decoder) NextTrack() (Track, error) {
+	if d.track < 0 {
+		return Track{}, io.EOF
+	}
+	if d.payload.N == 0 {
+		d.track = -1
+		if err := d.unattachedSteps(); err != nil {
+			return Track{}, err
 		}
-	case "0.909":
-		// TODO(aoeu): Is there no byte this value can be derived from?
-		p.Tempo = 240
-	case "0.708-alpha":
-		// TODO(aoeu): Is there no byte this value can be derived from?
-		p.Tempo = 999
+		return Track{}, io.EOF
 	}
-	var err error
-	p.Tracks, err = readAllTracks(d.r)
-	if err == io.ErrUnexpectedEOF {
-		return nil
+	t, err := d.readTrack(d.track, d.perTrack)
+	if err != nil {
+		d.track = -1
+		return t, err
 	}
-	return err
+	d.track++
+	return t, nil
 }
 
-func readAllTracks(r io.Reader) (Tracks, error) {
-	var t Tracks
-	for {
-		track, err := readTrack(r)
-		if err != nil {
-			if err == io.EOF {
-				return t, nil
+// Tracks returns an iterator over the tracks of the chunk whose header was
+// read by Header, which calls NextTrack until it returns io.EOF.
+// An error ends the iteration after it is yielded.
+func (d *Decoder) Tracks() iter.Seq2[Track, error] {
+	return func(yield func(Track, error) bool) {
+		for {
+			t, err := d.NextTrack()
+			if err == io.EOF || !yield(t, err) || err != nil {
+				return
 			}
-			return t, err
 		}
-		t = append(t, track)
 	}
 }
 
+// header reads the header of the next chunk into p, skipping the
+// unread tracks of the current chunk.
+func (d *Decoder) header(p *Pattern) error {
+	if track := d.track; track >= 0 {
+		d.track = -1
+		_, err := io.Copy(ioutil.Discard, d.payload)
+		if err == nil && d.payload.N > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return d.syntaxError(d.r.n, FieldTrackID, track, err)
+		}
+	}
+	id, err := d.readChunk()
+	if err != nil {
+		return err
+	}
+	p.TimeSignature = TimeSignature{}
+	p.Swing = Swing{}
+	p.Extensions = nil
+	d.steps = make(map[int]stepDetails)
+	if id == extChunkID {
+		if err := d.readExtension(p); err != nil {
+			return err
+		}
+		id, err = d.readChunk()
+		if err == io.EOF {
+			return d.syntaxError(d.r.n, FieldHeader, -1, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if id != chunkID {
+		return d.syntaxError(d.r.n-chunkHeaderSize, FieldHeader, -1, ErrBadMagic)
+	}
+	h := header{}
+	if err := d.readHeader(&h); err != nil {
+		return err
+	}
+	p.HardwareVersion, p.Reserved = splitVersion(h.HardwareVersion[:])
+	p.Tempo = h.Tempo
+	d.track, d.perTrack = 0, p.Steps()
+	return nil
+}
+
+// chunkHeaderSize is the encoded size of chunkHeader in bytes.
+const chunkHeaderSize = 14
+
+// readChunk reads the header of the next chunk and returns its identifier.
+// Subsequent reads are limited to the chunk's payload.
+func (d *Decoder) readChunk() (id string, err error) {
+	d.chunk++
+	d.payload = nil
+	offset := d.r.n
+	raw := make([]byte, chunkHeaderSize)
+	if _, err := io.ReadFull(d.r, raw); err != nil {
+		if err == io.EOF {
+			return "", err
+		}
+		return "", d.syntaxError(offset, FieldHeader, -1, err)
+	}
+	c := chunkHeader{}
+	binary.Read(bytes.NewReader(raw), binary.BigEndian, &c)
+	id = string(c.ID[:])
+	if id != chunkID && id != extChunkID && id != songChunkID {
+		return id, d.syntaxError(offset, FieldHeader, -1, ErrBadMagic)
+	}
+	n := len(c.ID)
+	d.mark(offset, FieldChunkID, -1, raw[:n], id)
+	d.mark(offset+int64(n), FieldChunkLength, -1, raw[n:], c.Length)
+	d.payload = &io.LimitedReader{R: d.r, N: int64(c.Length)}
+	return id, nil
+}
+
+// splitVersion splits a header's hardware version field into the
+// NUL-terminated version and the reserved bytes following it.
+// Reserved bytes are only returned if any of them are non-zero.
+func splitVersion(field []byte) (version string, reserved []byte) {
+	i := bytes.IndexByte(field, 0)
+	if i < 0 {
+		return string(field), nil
+	}
+	for _, b := range field[i+1:] {
+		if b != 0 {
+			reserved = append([]byte{}, field[i+1:]...)
+			break
+		}
+	}
+	return string(field[:i]), reserved
+}
+
+// readHeader decodes the header of a pattern chunk.
+func (d *Decoder) readHeader(h *header) error {
+	offset := d.r.n
+	raw := make([]byte, headerSize)
+	if _, err := io.ReadFull(d.payload, raw); err != nil {
+		return d.syntaxError(offset, FieldHeader, -1, err)
+	}
+	binary.Read(bytes.NewReader(raw), binary.LittleEndian, h)
+	n := len(h.HardwareVersion)
+	version, _ := splitVersion(h.HardwareVersion[:])
+	d.mark(offset, FieldVersion, -1, raw[:n], version)
+	d.mark(offset+int64(n), FieldTempo, -1, raw[n:], h.Tempo)
+	return nil
+}
+
+// read decodes data from the payload of the current chunk.
+func (d *Decoder) read(f Field, track int, data interface{}) error {
+	offset := d.r.n
+	raw := make([]byte, binary.Size(data))
+	if _, err := io.ReadFull(d.payload, raw); err != nil {
+		return d.syntaxError(offset, f, track, err)
+	}
+	binary.Read(bytes.NewReader(raw), binary.LittleEndian, data)
+	if d.MapFields {
+		value := reflect.Indirect(reflect.ValueOf(data)).Interface()
+		if _, ok := value.([]byte); ok {
+			// Keep the value from changing with the decoded pattern.
+			value = raw
+		}
+		d.mark(offset, f, track, raw, value)
+	}
+	return nil
+}
+
+// syntaxError describes a failure to decode field f starting at offset.
+// Running out of input is reported as ErrLengthMismatch if the
+// chunk's declared length was exhausted and as ErrTruncated otherwise.
+func (d *Decoder) syntaxError(offset int64, f Field, track int, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrTruncated
+		if d.payload != nil && d.payload.N == 0 {
+			err = ErrLengthMismatch
+		}
+	}
+	return &SyntaxError{Offset: offset, Field: f, Track: track, Chunk: d.chunk, Err: err}
+}
+
+// A countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // Tracks is a drum Track series that comprises the pattern.
 type Tracks []Track
 
@@ -89,26 +342,33 @@ func (t Tracks) String() string {
 	return s
 }
 
-func readTrack(r io.Reader) (Track, error) {
-	t := *NewTrack()
-	if err := binary.Read(r, binary.LittleEndian, &t.ID); err != nil {
-		return t, err
-	}
-	padding := make([]byte, 3)
-	if err := binary.Read(r, binary.LittleEndian, &padding); err != nil {
+func (d *Decoder) readTrack(i, steps int) (Track, error) {
+	t := Track{}
+	if err := d.read(FieldTrackID, i, &t.ID); err != nil {
 		return t, err
 	}
 	var nameLen byte
-	if err := binary.Read(r, binary.LittleEndian, &nameLen); err != nil {
+	if err := d.read(FieldNameLength, i, &nameLen); err != nil {
 		return t, err
 	}
 	nameBytes := make([]byte, nameLen)
-	if err := binary.Read(r, binary.LittleEndian, &nameBytes); err != nil {
+	if err := d.read(FieldName, i, &nameBytes); err != nil {
 		return t, err
 	}
 	t.Name = string(nameBytes)
-	if err := binary.Read(r, binary.LittleEndian, &t.Sequence); err != nil {
+	// A time signature can call for millions of steps per track,
+	// so check that the chunk holds them before allocating any.
+	if int64(steps) > d.payload.N {
+		offset := d.r.n
+		_, err := io.Copy(ioutil.Discard, d.payload)
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return t, d.syntaxError(offset, FieldSteps, i, err)
+	}
+	t.Sequence = make([]byte, steps)
+	if err := d.read(FieldSteps, i, &t.Sequence); err != nil {
 		return t, err
 	}
-	return t, nil
+	return t, d.attachSteps(i, &t)
 }
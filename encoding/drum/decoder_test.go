@@ -1,8 +1,14 @@
 package drum
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"path"
+	"reflect"
+	"runtime"
 	"testing"
 )
 
@@ -73,3 +79,311 @@ Tempo: 999
 		}
 	}
 }
+
+func TestDecodeTempo(t *testing.T) {
+	tData := []struct {
+		version string
+		tempo   float32
+	}{
+		{"0.808-alpha", 98.4},
+		{"0.909", 240},
+		{"1.2-unreleased", 133.25},
+	}
+	for _, exp := range tData {
+		b := new(bytes.Buffer)
+		in := Pattern{HardwareVersion: exp.version, Tempo: exp.tempo}
+		if err := NewEncoder(b).Encode(in); err != nil {
+			t.Fatal(err)
+		}
+		out := NewPattern()
+		if err := NewDecoder(b).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Tempo != exp.tempo {
+			t.Fatalf("Expected tempo %v for version %v but received %v",
+				exp.tempo, exp.version, out.Tempo)
+		}
+	}
+}
+
+func TestDecodeConcatenated(t *testing.T) {
+	paths := []string{"pattern_1.splice", "pattern_2.splice", "pattern_4.splice"}
+	var stream []byte
+	var expected []string
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(path.Join("patterns", p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, b...)
+		decoded, err := DecodeFile(path.Join("patterns", p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, fmt.Sprint(decoded))
+	}
+	d := NewDecoder(bytes.NewReader(stream))
+	var actual []string
+	for d.More() {
+		p := NewPattern()
+		if err := d.Decode(p); err != nil {
+			t.Fatalf("Could not decode chunk %v - %v", len(actual), err)
+		}
+		actual = append(actual, fmt.Sprint(p))
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %v patterns but received %v", len(expected), len(actual))
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("Chunk %v wasn't decoded as expected.\nGot:\n%s\nExpected:\n%s",
+				i, actual[i], expected[i])
+		}
+	}
+	if err := d.Decode(NewPattern()); err != io.EOF {
+		t.Fatalf("Expected io.EOF after the last chunk but received %v", err)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	b, err := ioutil.ReadFile(path.Join("patterns", "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{10, 30, len(b) - 16, len(b) - 1} {
+		err := NewDecoder(bytes.NewReader(b[:n])).Decode(NewPattern())
+		if !errors.Is(err, ErrTruncated) {
+			t.Fatalf("Expected ErrTruncated decoding %v bytes but received %v", n, err)
+		}
+	}
+}
+
+func TestDecodeSyntaxError(t *testing.T) {
+	pattern1, err := ioutil.ReadFile(path.Join("patterns", "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	badMagic := append([]byte("SPLICF"), pattern1[6:]...)
+	shortLength := append([]byte{}, pattern1...)
+	shortLength[13] -= 3
+	tData := []struct {
+		name  string
+		input []byte
+		err   error
+		exp   SyntaxError
+	}{
+		{"bad magic in first chunk", badMagic, ErrBadMagic,
+			SyntaxError{Offset: 0, Field: FieldHeader, Track: -1, Chunk: 0}},
+		{"bad magic in second chunk", append(append([]byte{}, pattern1...), badMagic...), ErrBadMagic,
+			SyntaxError{Offset: int64(len(pattern1)), Field: FieldHeader, Track: -1, Chunk: 1}},
+		{"truncated name", pattern1[:0x52], ErrTruncated,
+			SyntaxError{Offset: 0x50, Field: FieldName, Track: 1, Chunk: 0}},
+		{"length ends mid-track", shortLength, ErrLengthMismatch,
+			SyntaxError{Offset: 0xc3, Field: FieldSteps, Track: 5, Chunk: 0}},
+		{"length ends mid-header", pattern1[:14+20], ErrTruncated,
+			SyntaxError{Offset: 14, Field: FieldHeader, Track: -1, Chunk: 0}},
+		{"unsupported extension", append([]byte("SPLEXT\x00\x00\x00\x00\x00\x00\x00\x01\x09"), pattern1...),
+			ErrBadExtension, SyntaxError{Offset: 14, Field: FieldExtension, Track: -1, Chunk: 0}},
+		{"steps of a missing track", append([]byte("SPLEXT\x00\x00\x00\x00\x00\x00\x00\x08\x01\x02\x04\x00\x09\x00\x00\x00"), pattern1...),
+			ErrBadExtension, SyntaxError{Offset: 15, Field: FieldExtension, Track: 9, Chunk: 0}},
+		{"steps of missing tracks", append([]byte("SPLEXT\x00\x00\x00\x00\x00\x00\x00\x0f\x01\x02\x04\x00\x09\x00\x00\x00\x02\x04\x00\x07\x00\x00\x00"), pattern1...),
+			ErrBadExtension, SyntaxError{Offset: 22, Field: FieldExtension, Track: 7, Chunk: 0}},
+		{"more steps than the chunk holds", append([]byte("SPLEXT\x00\x00\x00\x00\x00\x00\x00\x07\x01\x01\x03\x00\xff\xff\xff"), pattern1...),
+			ErrLengthMismatch, SyntaxError{Offset: 21 + 59, Field: FieldSteps, Track: 0, Chunk: 1}},
+		{"extension without pattern", []byte("SPLEXT\x00\x00\x00\x00\x00\x00\x00\x01\x01"),
+			ErrTruncated, SyntaxError{Offset: 15, Field: FieldHeader, Track: -1, Chunk: 1}},
+	}
+	for _, exp := range tData {
+		d := NewDecoder(bytes.NewReader(exp.input))
+		for err = d.Decode(NewPattern()); err == nil; err = d.Decode(NewPattern()) {
+		}
+		if !errors.Is(err, exp.err) {
+			t.Fatalf("%v: Expected %v but received %v", exp.name, exp.err, err)
+		}
+		var actual *SyntaxError
+		if !errors.As(err, &actual) {
+			t.Fatalf("%v: Expected a *SyntaxError but received %T", exp.name, err)
+		}
+		exp.exp.Err = exp.err
+		if *actual != exp.exp {
+			t.Fatalf("%v: Expected %+v but received %+v", exp.name, exp.exp, *actual)
+		}
+	}
+}
+
+func TestDecodeHugeTimeSignature(t *testing.T) {
+	pattern1, err := ioutil.ReadFile(path.Join("patterns", "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 255 steps per beat, 255 beats per bar and 255 bars make
+	// over 16M steps per track, which the chunk does not hold.
+	input := append([]byte("SPLEXT\x00\x00\x00\x00\x00\x00\x00\x07\x01\x01\x03\x00\xff\xff\xff"), pattern1...)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err = NewDecoder(bytes.NewReader(input)).Decode(NewPattern())
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, ErrLengthMismatch) {
+		t.Fatalf("Expected %v but received %v", ErrLengthMismatch, err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("Expected to allocate less than 1MiB but allocated %v bytes", n)
+	}
+}
+
+func TestDecodeTrailingChunk(t *testing.T) {
+	b, err := ioutil.ReadFile(path.Join("patterns", "pattern_5.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(bytes.NewReader(b))
+	p := NewPattern()
+	if err := d.Decode(p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Tracks) != 2 {
+		t.Fatalf("Expected 2 tracks but received %v", len(p.Tracks))
+	}
+	if !d.More() {
+		t.Fatal("Expected the trailing chunk to be reported")
+	}
+	if err := d.Decode(NewPattern()); err == nil {
+		t.Fatal("Expected an error decoding the malformed trailing chunk")
+	}
+}
+
+func TestDecodeFields(t *testing.T) {
+	b, err := ioutil.ReadFile(path.Join("patterns", "pattern_2.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(bytes.NewReader(b))
+	d.MapFields = true
+	if err := d.Decode(NewPattern()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []FieldSpan{
+		{0, 6, FieldChunkID, -1, 0, []byte("SPLICE"), "SPLICE"},
+		{6, 8, FieldChunkLength, -1, 0, []byte{0, 0, 0, 0, 0, 0, 0, 0x8f}, uint64(0x8f)},
+		{14, 32, FieldVersion, -1, 0, append([]byte("0.808-alpha"), make([]byte, 21)...), "0.808-alpha"},
+		{46, 4, FieldTempo, -1, 0, []byte{0xcd, 0xcc, 0xc4, 0x42}, float32(98.4)},
+		{50, 4, FieldTrackID, 0, 0, []byte{0, 0, 0, 0}, uint32(0)},
+		{54, 1, FieldNameLength, 0, 0, []byte{4}, byte(4)},
+		{55, 4, FieldName, 0, 0, []byte("kick"), []byte("kick")},
+		{59, 16, FieldSteps, 0, 0, b[59:75], b[59:75]},
+		{75, 4, FieldTrackID, 1, 0, []byte{1, 0, 0, 0}, uint32(1)},
+	}
+	fields := d.Fields()
+	if len(fields) != 4+4*4 {
+		t.Fatalf("Expected %v fields but received %v", 4+4*4, len(fields))
+	}
+	for i, f := range expected {
+		if !reflect.DeepEqual(fields[i], f) {
+			t.Fatalf("Expected field %v to be %+v but received %+v", i, f, fields[i])
+		}
+	}
+	var end int64
+	for _, f := range fields {
+		if f.Offset != end {
+			t.Fatalf("Expected %+v to start at offset %v", f, end)
+		}
+		if int64(len(f.Raw)) != f.Length || !bytes.Equal(f.Raw, b[f.Offset:f.Offset+f.Length]) {
+			t.Fatalf("Expected the raw bytes of %+v to be % x", f, b[f.Offset:f.Offset+f.Length])
+		}
+		end += f.Length
+	}
+	if end != int64(len(b)) {
+		t.Fatalf("Expected fields to cover %v bytes but they cover %v", len(b), end)
+	}
+}
+
+func TestDecodeTrackStream(t *testing.T) {
+	paths := []string{"pattern_1.splice", "pattern_2.splice", "pattern_4.splice"}
+	var stream []byte
+	var expected []*Pattern
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(path.Join("patterns", p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, b...)
+		decoded, err := DecodeFile(path.Join("patterns", p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, decoded)
+	}
+	d := NewDecoder(bytes.NewReader(stream))
+	for i, exp := range expected {
+		p, err := d.Header()
+		if err != nil {
+			t.Fatalf("Could not decode the header of chunk %v - %v", i, err)
+		}
+		if len(p.Tracks) != 0 || p.HardwareVersion != exp.HardwareVersion || p.Tempo != exp.Tempo {
+			t.Fatalf("Chunk %v header decoded as:\n%v\nExpected:\n%v", i, p, exp)
+		}
+		if i == 1 {
+			// Unread tracks are skipped by the next header.
+			if _, err := d.NextTrack(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if i == 2 {
+			for track, err := range d.Tracks() {
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.Tracks = append(p.Tracks, track)
+			}
+		} else {
+			for {
+				track, err := d.NextTrack()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.Tracks = append(p.Tracks, track)
+			}
+		}
+		if p.String() != exp.String() {
+			t.Fatalf("Chunk %v wasn't decoded as expected.\nGot:\n%s\nExpected:\n%s", i, p, exp)
+		}
+		if _, err := d.NextTrack(); err != io.EOF {
+			t.Fatalf("Expected io.EOF after the last track but received %v", err)
+		}
+	}
+	if _, err := d.Header(); err != io.EOF {
+		t.Fatalf("Expected io.EOF after the last chunk but received %v", err)
+	}
+}
+
+func TestDecodeTrackStreamTruncated(t *testing.T) {
+	b, err := ioutil.ReadFile(path.Join("patterns", "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(bytes.NewReader(b[:0xc3]))
+	if _, err := d.Header(); err != nil {
+		t.Fatal(err)
+	}
+	var tracks int
+	for _, err := range d.Tracks() {
+		var serr *SyntaxError
+		if err != nil {
+			if !errors.As(err, &serr) || serr.Track != 5 || serr.Field != FieldSteps {
+				t.Fatalf("Expected a syntax error in the steps of track 5 but received %v", err)
+			}
+			break
+		}
+		tracks++
+	}
+	if tracks != 5 {
+		t.Fatalf("Expected 5 tracks before the error but received %v", tracks)
+	}
+	if _, err := d.NextTrack(); err != io.EOF {
+		t.Fatalf("Expected io.EOF after an error but received %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package drum
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A ChangeKind names what a Change changed.
+type ChangeKind string
+
+// Kinds of changes to the header, tracks and steps of a pattern.
+const (
+	ChangeVersion       ChangeKind = "version"
+	ChangeTempo         ChangeKind = "tempo"
+	ChangeTimeSignature ChangeKind = "time signature"
+	ChangeSwing         ChangeKind = "swing"
+	ChangeTrackRemoved  ChangeKind = "track removed"
+	ChangeTrackAdded    ChangeKind = "track added"
+	ChangeTrackRenamed  ChangeKind = "track renamed"
+	ChangeStep          ChangeKind = "step"
+)
+
+// A Change is a difference between two patterns.
+// Old and New hold the values before and after the change as written in
+// the text backup format. The value of a step is its beat character,
+// followed by a colon and its annotated details if it has any, such as
+// "x:p50,r2". The value of a track is its name.
+type Change struct {
+	Kind  ChangeKind
+	Track uint32 // ID of the track of track and step changes
+	Step  int    // index of the step of step changes
+	Old   string // "" for added tracks
+	New   string // "" for removed tracks
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case ChangeTrackRemoved:
+		return fmt.Sprintf("track %d %q removed", c.Track, c.Old)
+	case ChangeTrackAdded:
+		return fmt.Sprintf("track %d %q added", c.Track, c.New)
+	case ChangeTrackRenamed:
+		return fmt.Sprintf("track %d renamed %q -> %q", c.Track, c.Old, c.New)
+	case ChangeStep:
+		return fmt.Sprintf("track %d step %d %s -> %s", c.Track, c.Step, c.Old, c.New)
+	}
+	return fmt.Sprintf("%s %s -> %s", c.Kind, c.Old, c.New)
+}
+
+// Diff returns the changes turning pattern a into pattern b.
+// Tracks are matched by ID, and tracks sharing an ID in order.
+// The steps of a matched track are compared one by one, with the
+// steps past the end of the shorter track taken as rests.
+// Removed tracks are reported in the order of a, followed by the
+// changes of the other tracks in the order of b.
+func Diff(a, b *Pattern) []Change {
+	var changes []Change
+	header := func(kind ChangeKind, old, new string) {
+		if old != new {
+			changes = append(changes, Change{Kind: kind, Old: old, New: new})
+		}
+	}
+	header(ChangeVersion, strconv.Quote(a.HardwareVersion), strconv.Quote(b.HardwareVersion))
+	header(ChangeTempo, fmt.Sprint(a.Tempo), fmt.Sprint(b.Tempo))
+	header(ChangeTimeSignature, strings.TrimPrefix(a.signatureLine(), signaturePrefix),
+		strings.TrimPrefix(b.signatureLine(), signaturePrefix))
+	header(ChangeSwing, a.Swing.String(), b.Swing.String())
+
+	matches := matchTracks(a.Tracks, b.Tracks)
+	matched := make(map[int]bool)
+	for _, i := range matches {
+		if i >= 0 {
+			matched[i] = true
+		}
+	}
+	for i, t := range a.Tracks {
+		if !matched[i] {
+			changes = append(changes, Change{Kind: ChangeTrackRemoved, Track: t.ID, Old: t.Name})
+		}
+	}
+	for j, t := range b.Tracks {
+		i := matches[j]
+		if i < 0 {
+			changes = append(changes, Change{Kind: ChangeTrackAdded, Track: t.ID, New: t.Name})
+			continue
+		}
+		changes = append(changes, diffTracks(a.Tracks[i], t)...)
+	}
+	return changes
+}
+
+// matchTracks returns the index in a of the track matching each
+// track of b, or -1 for tracks of b that a does not have.
+func matchTracks(a, b []Track) []int {
+	indices := make(map[uint32][]int)
+	for i, t := range a {
+		indices[t.ID] = append(indices[t.ID], i)
+	}
+	matches := make([]int, len(b))
+	for j, t := range b {
+		matches[j] = -1
+		if i := indices[t.ID]; len(i) > 0 {
+			matches[j], indices[t.ID] = i[0], i[1:]
+		}
+	}
+	return matches
+}
+
+func diffTracks(a, b Track) []Change {
+	var changes []Change
+	if a.Name != b.Name {
+		changes = append(changes, Change{Kind: ChangeTrackRenamed, Track: a.ID, Old: a.Name, New: b.Name})
+	}
+	n := len(a.Sequence)
+	if len(b.Sequence) > n {
+		n = len(b.Sequence)
+	}
+	for i := 0; i < n; i++ {
+		if old, new := a.stepText(i), b.stepText(i); old != new {
+			changes = append(changes, Change{Kind: ChangeStep, Track: a.ID, Step: i, Old: old, New: new})
+		}
+	}
+	return changes
+}
+
+// stepText returns step i of the track as written in the text backup
+// format, taking steps past the end of the track as rests.
+func (t Track) stepText(i int) string {
+	if i >= len(t.Sequence) || t.Sequence[i] == 0 {
+		return string(offBeat)
+	}
+	if t.Sequence[i] != 1 {
+		return string(errorRune)
+	}
+	r, annotate := beatRune(t.Step(i))
+	if !annotate {
+		return string(r)
+	}
+	return string(r) + strings.TrimPrefix(annotation(i, t.Step(i)), strconv.Itoa(i))
+}
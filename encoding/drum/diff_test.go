@@ -0,0 +1,86 @@
+package drum
+
+import (
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := DecodeFile(path.Join("patterns", "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(a, a); len(changes) != 0 {
+		t.Fatalf("Expected no changes between equal patterns but received %v", changes)
+	}
+	b, err := NewPatternFromBackup(a.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.HardwareVersion = "0.909"
+	b.Tempo = 128
+	b.Swing = Swing{Amount: 62}
+	b.Tracks = b.Tracks[1:]
+	b.Tracks[0].Name = "rim"
+	b.Tracks[0].Sequence[0] = 1
+	b.Tracks[0].Sequence[4] = 0
+	b.Tracks[1].Steps = make([]Step, len(b.Tracks[1].Sequence))
+	for i, v := range b.Tracks[1].Sequence {
+		if v == 1 {
+			b.Tracks[1].Steps[i] = DefaultStep
+		}
+	}
+	b.Tracks[1].Steps[4] = Step{Velocity: AccentVelocity, Probability: 50, Ratchets: 2}
+	b.Tracks = append(b.Tracks, Track{ID: 9, Name: "cowbell", Sequence: make([]byte, 16)})
+
+	expected := []Change{
+		{Kind: ChangeVersion, Old: `"0.808-alpha"`, New: `"0.909"`},
+		{Kind: ChangeTempo, Old: "120", New: "128"},
+		{Kind: ChangeSwing, Old: "0%", New: "62%"},
+		{Kind: ChangeTrackRemoved, Track: 0, Old: "kick"},
+		{Kind: ChangeTrackRenamed, Track: 1, Old: "snare", New: "rim"},
+		{Kind: ChangeStep, Track: 1, Step: 0, Old: "-", New: "x"},
+		{Kind: ChangeStep, Track: 1, Step: 4, Old: "x", New: "-"},
+		{Kind: ChangeStep, Track: 2, Step: 4, Old: "x", New: "X:p50,r2"},
+		{Kind: ChangeTrackAdded, Track: 9, New: "cowbell"},
+	}
+	changes := Diff(a, b)
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Expected changes:\n%v\nReceived:\n%v", expected, changes)
+	}
+	for i, s := range []string{
+		`version "0.808-alpha" -> "0.909"`,
+		`tempo 120 -> 128`,
+		`swing 0% -> 62%`,
+		`track 0 "kick" removed`,
+		`track 1 renamed "snare" -> "rim"`,
+		`track 1 step 0 - -> x`,
+	} {
+		if changes[i].String() != s {
+			t.Fatalf("Expected '%v' but received '%v'", s, changes[i])
+		}
+	}
+}
+
+func TestDiffTimeSignature(t *testing.T) {
+	a, err := NewPatternFromBackup("(1) hh\t|x-x-|x-x-|x-x-|x-x-|\n(1) hh\t|x---|x---|x---|x---|\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewPatternFromBackup("Steps: 4 per beat, 4 beats per bar, 2 bars\n" +
+		"(1) hh\t|x-x-|x-x-|x-x-|x-x-|x---|x---|x---|x---|\n(1) hh\t|x---|x---|x---|x---|----|----|----|----|\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Kind: ChangeTimeSignature, Old: "4 per beat, 4 beats per bar, 1 bars", New: "4 per beat, 4 beats per bar, 2 bars"},
+		{Kind: ChangeStep, Track: 1, Step: 16, Old: "-", New: "x"},
+		{Kind: ChangeStep, Track: 1, Step: 20, Old: "-", New: "x"},
+		{Kind: ChangeStep, Track: 1, Step: 24, Old: "-", New: "x"},
+		{Kind: ChangeStep, Track: 1, Step: 28, Old: "-", New: "x"},
+	}
+	if changes := Diff(a, b); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Expected changes:\n%v\nReceived:\n%v", expected, changes)
+	}
+}
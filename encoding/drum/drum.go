@@ -3,32 +3,52 @@
 package drum
 
 import (
-	"bufio"
 	"bytes"
+	"encoding/binary"
 	"fmt"
-	"regexp"
-	"strconv"
 	"strings"
 )
 
+// chunkID marks the start of every pattern chunk in a .splice stream.
+const chunkID = "SPLICE"
+
+// A chunkHeader precedes each pattern chunk and is stored big-endian.
+// Length counts the payload bytes following the chunk header.
+type chunkHeader struct {
+	ID     [6]byte
+	Length uint64
+}
+
+// headerSize is the encoded size of header in bytes.
+const headerSize = 36
+
+// A header opens the payload of a pattern chunk and is stored little-endian.
 type header struct {
-	ChunkID         [6]byte
-	Padding1        [7]byte
-	Unknown1        [1]byte
-	HardwareVersion [31]byte
-	Unknown2        [2]byte
-	TempoDecimal    byte
-	Tempo           byte
-	Unknown3        byte
+	HardwareVersion [32]byte
+	Tempo           float32
 }
 
 // Pattern is the high level representation of the
 // drum pattern contained in a .splice file.
+//
+// Bytes of a chunk the decoder does not understand are kept
+// in Reserved and Trailing and written back verbatim by an Encoder,
+// so that decoding and re-encoding a pattern never loses data.
 type Pattern struct {
-	Tempo           int
-	TempoDecimal    int
+	Tempo           float32
 	HardwareVersion string
+	TimeSignature
+	Swing Swing
 	Tracks
+	// Reserved holds the bytes of the header's hardware version field
+	// following the NUL that terminates the version, if any are non-zero.
+	Reserved []byte
+	// Trailing holds the bytes that followed the pattern's chunk
+	// in the file it was decoded from.
+	Trailing []byte
+	// Extensions holds the extension records with tags
+	// the decoder does not understand.
+	Extensions []byte
 }
 
 // NewPattern returns an empty pattern.
@@ -39,158 +59,121 @@ func NewPattern() *Pattern {
 }
 
 func (p Pattern) String() string {
-	bpm := fmt.Sprint(p.Tempo)
-	if p.TempoDecimal != 0 {
-		bpm = fmt.Sprintf("%v.%v", p.Tempo, p.TempoDecimal)
+	s := p.versionLine() + "\n" + p.tempoLine() + "\n"
+	sig := p.TimeSignature.orDefault()
+	if sig != DefaultTimeSignature {
+		s += p.signatureLine() + "\n"
+	}
+	if p.Swing != (Swing{}) {
+		s += p.swingLine() + "\n"
+	}
+	for _, t := range p.Tracks {
+		s += t.format(sig.StepsPerBeat) + "\n"
 	}
-	s := fmt.Sprintf("Saved with HW Version: %s\nTempo: %v\n%v", p.HardwareVersion, bpm, p.Tracks)
 	return s
 }
 
-func (p Pattern) header() header {
+func (p Pattern) header() (header, error) {
 	h := header{}
-	h.ChunkID = [6]byte{'S', 'P', 'L', 'I', 'C', 'E'}
-	for i, r := range p.HardwareVersion {
-		h.HardwareVersion[i] = byte(r)
+	if len(p.HardwareVersion) > len(h.HardwareVersion) {
+		return h, fmt.Errorf("drum: hardware version %q exceeds %d bytes",
+			p.HardwareVersion, len(h.HardwareVersion))
 	}
-	if p.HardwareVersion == "0.808-alpha" {
-		if p.TempoDecimal != 0 {
-			h.TempoDecimal = byte(p.TempoDecimal + 200)
-		}
-		h.Tempo = byte(p.Tempo * 2)
+	if strings.IndexByte(p.HardwareVersion, 0) >= 0 {
+		return h, fmt.Errorf("drum: hardware version %q holds a NUL byte", p.HardwareVersion)
 	}
-	return h
-}
-
-// NewPatternFromBackup creates a pattern structure by parsing
-// a backup file's human-readible text data.
-func NewPatternFromBackup(s string) (*Pattern, error) {
-	scanner := bufio.NewScanner(strings.NewReader(s))
-	p := NewPattern()
-	for lineNum := 0; scanner.Scan(); lineNum++ {
-		line := scanner.Text()
-		switch lineNum {
-		case 0:
-			p.HardwareVersion = parseHardwareVersion(line)
-		case 1:
-			var err error
-			p.Tempo, p.TempoDecimal, err = parseTempo(line)
-			if err != nil {
-				return p, err
-			}
-		default:
-			t, err := parseTrack(line)
-			if err != nil {
-				return p, err
-			}
-			p.Tracks = append(p.Tracks, t)
+	n := copy(h.HardwareVersion[:], p.HardwareVersion)
+	if len(p.Reserved) > 0 {
+		if n+1+len(p.Reserved) > len(h.HardwareVersion) {
+			return h, fmt.Errorf("drum: hardware version %q leaves no room for %d reserved bytes",
+				p.HardwareVersion, len(p.Reserved))
 		}
+		copy(h.HardwareVersion[n+1:], p.Reserved)
 	}
-	return p, nil
-}
-
-func parseHardwareVersion(line string) string {
-	s := strings.TrimLeft(line, "Saved with HW Version: ")
-	return s
+	h.Tempo = p.Tempo
+	return h, nil
 }
 
-func parseTempo(line string) (tempo, tempoDecimal int, err error) {
-	s := strings.TrimLeft(line, "Tempo: ")
-	match := tempoRe.FindStringSubmatch(s)
-	tempo, err = strconv.Atoi(match[1])
+// encode returns the chunk payload holding the pattern's header and tracks.
+func (p Pattern) encode() ([]byte, error) {
+	h, err := p.header()
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
-	tempoDecimal, err = strconv.Atoi(match[2])
-	if err != nil {
-		return tempo, 0, nil
+	b := bytes.NewBuffer(make([]byte, 0, headerSize))
+	if err := binary.Write(b, binary.LittleEndian, h); err != nil {
+		return nil, err
 	}
-	return tempo, tempoDecimal, nil
+	for i, t := range p.Tracks {
+		if err := t.validate(i, p.Steps()); err != nil {
+			return nil, err
+		}
+		b.Write(t.encode())
+	}
+	return b.Bytes(), nil
 }
 
-var idRe = regexp.MustCompile(`\((\d+)\) `)
-var tempoRe = regexp.MustCompile("(\\d+).?(\\d+)?")
-var beatRe = regexp.MustCompile(`([x-]{4})\|`)
+const (
+	signaturePrefix = "Steps: "
+	signatureFormat = signaturePrefix + "%d per beat, %d beats per bar, %d bars"
+)
 
-func parseTrack(line string) (Track, error) {
-	id, line, err := parseTrackID(line)
-	if err != nil {
-		return Track{}, err
-	}
-	name, line := parseTrackName(line)
-	bars, line, err := parseBar(line, 4)
-	if err != nil {
-		return Track{}, err
-	}
-	return Track{Name: name, ID: id, Sequence: bars}, nil
-}
+// The lines of the text backup header.
 
-func parseTrackID(line string) (id byte, leftTrimmedLine string, err error) {
-	idMatch := idRe.FindStringSubmatch(line)
-	if len(idMatch) != 2 {
-		return 0, "", fmt.Errorf("No track ID parsed from line: '%v'", line)
-	}
-	n, err := strconv.Atoi(idMatch[1])
-	if err != nil {
-		return id, leftTrimmedLine, err
-	}
-	leftTrimmedLine = strings.TrimLeft(line, idMatch[0])
-	return byte(n), leftTrimmedLine, nil
+func (p Pattern) versionLine() string {
+	return versionPrefix + " " + p.HardwareVersion
 }
 
-var nameRe = regexp.MustCompile(`([\w-]+)\s+\|`)
-
-func parseTrackName(line string) (name, leftTrimmedLine string) {
-	s := strings.SplitN(line, "|", 2)
-	name = strings.TrimRight(s[0], " \t")
-	return name, s[1]
+func (p Pattern) tempoLine() string {
+	return fmt.Sprintf("Tempo: %v", p.Tempo)
 }
 
-func parseBar(line string, numMeasures int) (bar []byte, leftTrimmedLine string, err error) {
-	measureMatches := beatRe.FindAllStringSubmatch(line, numMeasures)
-	for i := 0; i < numMeasures; i++ {
-		measure := measureMatches[i][1]
-		beats, err := parseBeats(measure)
-		if err != nil {
-			return beats, leftTrimmedLine, err
-		}
-		bar = append(bar, beats...)
-		line = strings.TrimLeft(line, measureMatches[i][0])
-	}
-	return bar, line, nil
+func (p Pattern) signatureLine() string {
+	sig := p.TimeSignature.orDefault()
+	return fmt.Sprintf(signatureFormat, sig.StepsPerBeat, sig.BeatsPerBar, sig.Bars)
 }
 
-func parseBeats(measure string) ([]byte, error) {
-	var beats []byte
-	for _, beat := range measure {
-		switch beat {
-		case onBeat:
-			beats = append(beats, 1)
-		case offBeat:
-			beats = append(beats, 0)
-		default:
-			return beats, fmt.Errorf("Unknown beat character '%v' encountered parsing measure '%v'", beat, measure)
-		}
-	}
-	return beats, nil
+func (p Pattern) swingLine() string {
+	return swingPrefix + p.Swing.String()
 }
 
 // A Track represents a named, identified drum sequence.
+// Hits in the sequence are marked by a 1.
 type Track struct {
-	ID       byte
+	ID       uint32
 	Name     string
 	Sequence []byte
+	// Steps optionally holds the details of every step in Sequence,
+	// see the Step method.
+	Steps []Step
 }
 
-// NewTrack returns an empty, initialized track.
+// maxNameLen is the longest track name a byte length prefix can hold.
+const maxNameLen = 255
+
+// validate reports whether track i of a pattern of the given steps
+// can be encoded.
+func (t Track) validate(i, steps int) error {
+	if len(t.Name) > maxNameLen {
+		return fmt.Errorf("drum: track %d name %q exceeds %d bytes", i, t.Name, maxNameLen)
+	}
+	if len(t.Sequence) != steps {
+		return fmt.Errorf("drum: track %d has %d steps instead of %d", i, len(t.Sequence), steps)
+	}
+	return t.validateSteps()
+}
+
+// NewTrack returns an empty, initialized track
+// with as many steps as the default time signature.
 func NewTrack() *Track {
 	t := new(Track)
-	t.Sequence = make([]byte, 16)
+	t.Sequence = make([]byte, DefaultTimeSignature.Steps())
 	return t
 }
 
 func (t Track) encode() []byte {
-	b := []byte{t.ID, 0, 0, 0}
+	b := make([]byte, 4, 5+len(t.Name)+len(t.Sequence))
+	binary.LittleEndian.PutUint32(b, t.ID)
 	b = append(b, byte(len(t.Name)))
 	b = append(b, []byte(t.Name)...)
 	b = append(b, t.Sequence...)
@@ -205,15 +188,26 @@ const (
 )
 
 func (t Track) String() string {
+	return t.format(DefaultTimeSignature.StepsPerBeat)
+}
+
+// format renders the track with a separator between every beat,
+// followed by annotations of step details the beat characters cannot show.
+func (t Track) format(stepsPerBeat int) string {
 	var b bytes.Buffer
+	var annotations []string
 	b.WriteString(fmt.Sprintf("(%d) %s\t", t.ID, t.Name))
 	for i := 0; i < len(t.Sequence); i++ {
-		if i%4 == 0 {
+		if i%stepsPerBeat == 0 {
 			b.WriteString(string(separator))
 		}
 		switch t.Sequence[i] {
 		case 1:
-			b.WriteString(string(onBeat))
+			r, annotate := beatRune(t.Step(i))
+			if annotate {
+				annotations = append(annotations, annotation(i, t.Step(i)))
+			}
+			b.WriteRune(r)
 		case 0:
 			b.WriteString(string(offBeat))
 		default:
@@ -221,5 +215,8 @@ func (t Track) String() string {
 		}
 	}
 	b.WriteString(string(separator))
+	if len(annotations) > 0 {
+		b.WriteString("\t" + strings.Join(annotations, " "))
+	}
 	return b.String()
 }
@@ -1,102 +1,188 @@
 package drum
 
 import (
+	"math"
 	"testing"
 )
 
+// parseLine parses a backup holding the line after a header.
+func parseLine(line string) (*Pattern, error) {
+	return NewPatternFromBackup("Saved with HW Version: 0.808-alpha\nTempo: 120\n" + line)
+}
+
 func TestParseHardwareVersion(t *testing.T) {
-	expected := "0.808-alpha"
-	actual := parseHardwareVersion("Saved with HW Version: " + expected)
-	if actual != expected {
-		t.Fatalf(`Expected "%v" but received "%v"`, expected, actual)
+	for _, expected := range []string{"0.808-alpha", "S-1", "Version 2 (beta)"} {
+		p, err := NewPatternFromBackup("Saved with HW Version: " + expected + "\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.HardwareVersion != expected {
+			t.Fatalf(`Expected "%v" but received "%v"`, expected, p.HardwareVersion)
+		}
 	}
 }
 
 func TestParseTempo(t *testing.T) {
 	tData := []struct {
-		input        string
-		tempo        int
-		tempoDecimal int
+		input string
+		tempo float32
 	}{
-		{"Tempo: 120", 120, 0},
-		{"Tempo: 99", 99, 0},
-		{"Tempo: 91.3", 91, 3},
+		{"Tempo: 120", 120},
+		{"Tempo: 99", 99},
+		{"Tempo: 91.3", 91.3},
+		{"Tempo: 98.45", 98.45},
+		{"Tempo:98.45 # comment", 98.45},
 	}
 	for _, expected := range tData {
-		tempo, tempoDecimal, err := parseTempo(expected.input)
+		p, err := NewPatternFromBackup(expected.input)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if tempo != expected.tempo {
-			t.Fatalf("Expected tempo %v but received %v", expected.tempo, tempo)
-		}
-		if tempoDecimal != expected.tempoDecimal {
-			t.Fatalf("Expected tempo decimal %v but received %v", expected.tempoDecimal, tempoDecimal)
+		if p.Tempo != expected.tempo {
+			t.Fatalf("Expected tempo %v but received %v", expected.tempo, p.Tempo)
 		}
-
 	}
 }
 
-func TestParseTrackId(t *testing.T) {
-	input := "(3) hh-open	|--x-|--x-|x-x-|--x-|"
-	var expectedID byte = 3
-	expectedLine := "hh-open	|--x-|--x-|x-x-|--x-|"
-	actualID, actualLine, err := parseTrackID(input)
+func TestParseTimeSignature(t *testing.T) {
+	expected := TimeSignature{StepsPerBeat: 3, BeatsPerBar: 4, Bars: 2}
+	p, err := parseLine("Steps: 3 per beat, 4 beats per bar, 2 bars")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if expectedID != actualID {
-		t.Fatalf("Expected ID %v but received %v", expectedID, actualID)
+	if p.TimeSignature != expected {
+		t.Fatalf("Expected %+v but received %+v", expected, p.TimeSignature)
 	}
-	if expectedLine != actualLine {
-		t.Fatalf("Expected line '%v' but received '%v'", expectedLine, actualLine)
+	if _, err := parseLine("Steps: 0 per beat, 4 beats per bar, 2 bars"); err == nil {
+		t.Fatal("Expected an error parsing a time signature without steps")
 	}
 }
 
-func TestParseTrackName(t *testing.T) {
-	input := "hh-open	|--x-|--x-|x-x-|--x-|"
-	expectedName := "hh-open"
-	expectedLine := "--x-|--x-|x-x-|--x-|"
-	actualName, actualLine := parseTrackName(input)
-	if expectedName != actualName {
-		t.Fatalf("Expected name '%v' but received '%v'", expectedName, actualName)
+func TestParseSwing(t *testing.T) {
+	tData := []struct {
+		line     string
+		expected Swing
+	}{
+		{"Swing: 62%", Swing{Amount: 62}},
+		{"Swing: 75% 8th", Swing{Amount: 75, Resolution: 8}},
+		{"Swing: 50% 16th", Swing{Amount: 50, Resolution: 16}},
+	}
+	for _, input := range tData {
+		p, err := parseLine(input.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Swing != input.expected {
+			t.Fatalf("Expected %+v from '%v' but received %+v", input.expected, input.line, p.Swing)
+		}
+		if line := swingPrefix + p.Swing.String(); line != input.line {
+			t.Fatalf("Expected '%v' but received '%v'", input.line, line)
+		}
+	}
+	for _, line := range []string{"Swing: 80%", "Swing: 49%", "Swing: 60% 4th", "Swing: 60", "Swing: "} {
+		if _, err := parseLine(line); err == nil {
+			t.Fatalf("Expected an error parsing '%v'", line)
+		}
+	}
+}
+
+func TestSwingApply(t *testing.T) {
+	tData := []struct {
+		swing    Swing
+		quarters []float64
+		expected []float64
+	}{
+		{Swing{}, []float64{0, 0.25, 0.5, 0.75}, []float64{0, 0.25, 0.5, 0.75}},
+		{Swing{Amount: 50}, []float64{0, 0.25, 0.5, 0.75}, []float64{0, 0.25, 0.5, 0.75}},
+		{Swing{Amount: 60}, []float64{0, 0.25, 0.5, 0.75, 1.25}, []float64{0, 0.3, 0.5, 0.8, 1.3}},
+		{Swing{Amount: 75, Resolution: 8}, []float64{0, 0.25, 0.5, 0.75, 1}, []float64{0, 0.375, 0.75, 0.875, 1}},
+	}
+	for _, input := range tData {
+		for i, q := range input.quarters {
+			if actual := input.swing.Apply(q); math.Abs(actual-input.expected[i]) > 1e-9 {
+				t.Fatalf("Expected %+v to play %v at %v but received %v", input.swing, q, input.expected[i], actual)
+			}
+		}
+	}
+}
+
+func TestParseTrackId(t *testing.T) {
+	tData := []struct {
+		input string
+		id    uint32
+	}{
+		{"(3) hh-open	|--x-|--x-|x-x-|--x-|", 3},
+		{"(300) hh-open	|--x-|--x-|x-x-|--x-|", 300},
+		{"(4294967295) hh-open	|--x-|--x-|x-x-|--x-|", 4294967295},
 	}
-	if expectedLine != actualLine {
-		t.Fatalf("Expected line '%v' but received '%v'", expectedLine, actualLine)
+	for _, expected := range tData {
+		p, err := parseLine(expected.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected.id != p.Tracks[0].ID {
+			t.Fatalf("Expected ID %v but received %v", expected.id, p.Tracks[0].ID)
+		}
 	}
+	if _, err := parseLine("(4294967296) hh-open	|--x-|--x-|x-x-|--x-|"); err == nil {
+		t.Fatal("Expected an error parsing an ID that overflows 32 bits")
+	}
+}
 
+func TestParseTrackName(t *testing.T) {
+	for input, expected := range map[string]string{
+		"(1) hh-open	|--x-|--x-|x-x-|--x-|":     "hh-open",
+		"(1) Low Conga	|--x-|--x-|x-x-|--x-|":   "Low Conga",
+		"(1)   (open) hh |--x-|--x-|x-x-|--x-|": "(open) hh",
+		"(1)	|--x-|--x-|x-x-|--x-|":             "",
+	} {
+		p, err := parseLine(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Tracks[0].Name != expected {
+			t.Fatalf("Expected name '%v' but received '%v'", expected, p.Tracks[0].Name)
+		}
+	}
 }
 
-// func parseBar(line string, numMeasures int) (bar []byte, subLine string)
 func TestParseBar(t *testing.T) {
-	input := "--x-|--x-|x-x-|--x-|"
-	expected := []byte{0, 0, 1, 0, 0, 0, 1, 0, 1, 0, 1, 0, 0, 0, 1, 0}
-	actual, s, err := parseBar(input, 4)
+	x, o := DefaultStep, Step{}
+	expected := []Step{o, o, x, o, o, o, x, o, x, o, x, o, o, o, x, o}
+	p, err := parseLine("(3) hh-open	|--x-|--x-|x-x-|--x-|")
 	if err != nil {
 		t.Fatalf("Received unexpected error: %v", err)
 	}
-	for i, b := range actual {
-		if expected[i] != b {
-			t.Fatalf("Expected '%v' but received '%v' at %v",
-				expected, actual, i)
+	for i, b := range expected {
+		if p.Tracks[0].Step(i) != b {
+			t.Fatalf("Expected '%v' but received '%v' at %v", b, p.Tracks[0].Step(i), i)
 		}
 	}
-	if s != "" {
-		t.Fatalf("Expected empty string but received '%v'", s)
+	for _, line := range []string{
+		"(3) hh-open	|--x-|--x-|",
+		"(3) hh-open	|--x-|--x-|x-x-|--x-",
+		"(3) hh-open	|--x-|--x-|x-x-|--x-|--x-|",
+		"(3) hh-open	|--x-|--x-|x-x-|--x",
+		"(3) hh-open",
+	} {
+		if _, err := parseLine(line); err == nil {
+			t.Fatalf("Expected an error parsing '%v'", line)
+		}
 	}
 }
 
 func TestParseBeats(t *testing.T) {
-	measure := "--x-"
-	expected := []byte{0, 0, 1, 0}
-	actual, err := parseBeats(measure)
+	expected := []Step{{}, runeStep('X'), DefaultStep, runeStep('o')}
+	if expected[1].Velocity != AccentVelocity || expected[3].Velocity != GhostVelocity {
+		t.Fatalf("Unexpected accent or ghost steps %v", expected)
+	}
+	p, err := parseLine("Steps: 4 per beat, 1 beats per bar, 1 bars\n(0) kick |-Xxo|")
 	if err != nil {
 		t.Fatalf("Received unexpected error: %v", err)
 	}
-	for i, b := range actual {
-		if expected[i] != b {
-			t.Fatalf("Expected '%v' but received '%v' at %v",
-				expected, actual, i)
+	for i, b := range expected {
+		if p.Tracks[0].Step(i) != b {
+			t.Fatalf("Expected '%v' but received '%v' at %v", b, p.Tracks[0].Step(i), i)
 		}
 	}
 }
@@ -136,3 +222,50 @@ Tempo: 120
 		t.Fatalf("Expected %v tracks but received %v tracks", expectedNumTracks, actualNumTracks)
 	}
 }
+
+func TestTrackSteps(t *testing.T) {
+	backup := "(7) snare\t|X---|o-x-|x---|x---|\t6:p50 8:v90,t-12,r3"
+	p, err := parseLine(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := p.Tracks[0]
+	expectedSeq := []byte{1, 0, 0, 0, 1, 0, 1, 0, 1, 0, 0, 0, 1, 0, 0, 0}
+	if string(track.Sequence) != string(expectedSeq) {
+		t.Fatalf("Expected sequence %v but received %v", expectedSeq, track.Sequence)
+	}
+	expected := map[int]Step{
+		0:  {Velocity: AccentVelocity, Probability: 100, Ratchets: 1},
+		1:  {},
+		4:  {Velocity: GhostVelocity, Probability: 100, Ratchets: 1},
+		6:  {Velocity: DefaultVelocity, Probability: 50, Ratchets: 1},
+		8:  {Velocity: 90, Probability: 100, Offset: -12, Ratchets: 3},
+		12: DefaultStep,
+	}
+	for i, s := range expected {
+		if track.Step(i) != s {
+			t.Fatalf("Expected step %v to be %+v but received %+v", i, s, track.Step(i))
+		}
+	}
+	if track.String() != backup {
+		t.Fatalf("Expected track '%v' but received '%v'", backup, track)
+	}
+	p, err = parseLine("(1) kick\t|x---|x---|x---|x---|")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain := p.Tracks[0]; plain.Steps != nil {
+		t.Fatalf("Expected no step details for a plain track but received %v", plain.Steps)
+	}
+	short := Track{Sequence: []byte{1, 0, 1}, Steps: []Step{{Velocity: 90, Probability: 100, Ratchets: 1}}}
+	for i, s := range []Step{{Velocity: 90, Probability: 100, Ratchets: 1}, {}, DefaultStep} {
+		if short.Step(i) != s {
+			t.Fatalf("Expected step %v of a track with short step details to be %+v but received %+v", i, s, short.Step(i))
+		}
+	}
+	for _, bad := range []string{"1:p50", "0:v0", "0:q1", "0:t200", "16:p1", "0:", "0:v", "0:p+5", "0"} {
+		if _, err := parseLine("(7) snare\t|X---|o-x-|x---|x---|\t" + bad); err == nil {
+			t.Fatalf("Expected an error parsing step annotation '%v'", bad)
+		}
+	}
+}
@@ -1,42 +1,325 @@
 package drum
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strconv"
 )
 
-// EncodeFile a text file backup in a custom binary data format.
+// EncodeFile writes a pattern to the file at path in the binary format,
+// creating it with permissions 0666 (before umask) or replacing it
+// atomically. It is shorthand for NewFileEncoder().EncodeFile(path, pattern).
 func EncodeFile(path string, pattern Pattern) error {
-	f, err := os.Open(path)
+	return NewFileEncoder().EncodeFile(path, pattern)
+}
+
+// A FileEncoder writes patterns to files.
+//
+// A pattern is written to a temporary file in the directory of the target,
+// which is synced to disk and renamed over the target, so that the target
+// holds either its previous contents or the whole pattern even if
+// encoding fails or the system crashes.
+type FileEncoder struct {
+	// Perm is the permissions of a created file, before umask.
+	// A replaced file keeps its permissions.
+	Perm os.FileMode
+	// Backup keeps the previous contents of a replaced file
+	// at its path with ".bak" appended.
+	Backup bool
+}
+
+// NewFileEncoder returns a file encoder creating files with
+// permissions 0666 and keeping no backups.
+func NewFileEncoder() *FileEncoder {
+	return &FileEncoder{Perm: 0666}
+}
+
+// EncodeFile writes a pattern to the file at path. If path is a symbolic
+// link, the file it refers to is replaced, or created if it does not exist.
+func (fe *FileEncoder) EncodeFile(path string, pattern Pattern) error {
+	target, err := resolveLinks(path)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	e := NewEncoder(f)
-	err = e.Encode(pattern)
-	return err
+	encode := func(w io.Writer) error { return NewEncoder(w).Encode(pattern) }
+	info, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		return writeFileAtomic(target, fe.Perm, nil, encode)
+	}
+	if err != nil {
+		return err
+	}
+	if fe.Backup {
+		b, err := ioutil.ReadFile(target)
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomic(target+".bak", fe.Perm, info, func(w io.Writer) error {
+			_, err := w.Write(b)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(target, fe.Perm, info, encode)
+}
+
+// maxLinks is the number of symbolic links resolveLinks follows.
+const maxLinks = 255
+
+// resolveLinks returns the path a chain of symbolic links at path refers
+// to, whether or not a file exists there.
+func resolveLinks(path string) (string, error) {
+	for i := 0; i < maxLinks; i++ {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+		link, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(path), link)
+		}
+		path = link
+	}
+	return "", fmt.Errorf("drum: too many symbolic links resolving %s", path)
+}
+
+// writeFileAtomic writes a file with write to a temporary file and renames
+// it to path. The temporary file is created with perm, or given the
+// permissions of old, the file being replaced, if it is not nil.
+func writeFileAtomic(path string, perm os.FileMode, old os.FileInfo, write func(io.Writer) error) (err error) {
+	f, err := createTemp(path, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if old != nil {
+		// Chmod is not subject to the umask, unlike the creation of the file.
+		if err := f.Chmod(old.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if err := write(f); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// createTemp creates a new hidden file beside the file at path.
+func createTemp(path string, perm os.FileMode) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, "."+base+"."+strconv.FormatUint(uint64(rand.Uint32()), 36)+".tmp")
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// syncDir makes a rename in dir durable where the system supports
+// syncing directories, and does nothing elsewhere.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
 }
 
 // An Encoder represents a parser to binary from a drum pattern.
+//
+// Unless Backfill is set, the tracks written by WriteTrack are kept in
+// memory until Flush writes the chunk, so streaming a chunk through an
+// encoder does not bound its memory use.
 type Encoder struct {
 	w io.Writer
+	// Backfill makes WriteHeader write the chunk as it goes if the output
+	// stream is an io.WriteSeeker, such as a file, for Flush to seek back
+	// and write its length. It must not be set for files opened with
+	// O_APPEND, such as standard output redirected with >>, where writes
+	// land at the end of the file whatever the seek offset.
+	Backfill bool
+
+	// The chunk being written by WriteHeader and WriteTrack.
+	steps   int            // of the tracks of the chunk, or 0 if there is none
+	tracks  int            // written so far
+	length  uint64         // of the payload written so far
+	seeker  io.WriteSeeker // w, if the length is backfilled
+	start   int64          // offset of the chunk header in seeker
+	payload *bytes.Buffer  // payload kept until Flush if w cannot seek
 }
 
 // NewEncoder creates a new drum pattern encoder writing to w.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w}
+	return &Encoder{w: w}
 }
 
-// Encode writes to the encoder's output stream to serialize a drum pattern.
-func (e Encoder) Encode(p Pattern) error {
-	if err := binary.Write(e.w, binary.LittleEndian, p.header()); err != nil {
+// Encode writes to the encoder's output stream to serialize a drum pattern
+// as a single chunk followed by the pattern's Trailing bytes,
+// after finishing any chunk written by WriteHeader.
+// Settings the hardware format has no room for, such as a time signature
+// other than DefaultTimeSignature, are written to a preceding extension chunk.
+func (e *Encoder) Encode(p Pattern) error {
+	if err := e.Flush(); err != nil {
 		return err
 	}
-	for _, t := range p.Tracks {
-		if err := binary.Write(e.w, binary.LittleEndian, t.encode()); err != nil {
+	if err := e.writePattern(p); err != nil {
+		return err
+	}
+	_, err := e.w.Write(p.Trailing)
+	return err
+}
+
+// writePattern writes the chunk of a pattern and its extension chunk.
+func (e *Encoder) writePattern(p Pattern) error {
+	ext, err := p.extension()
+	if err != nil {
+		return err
+	}
+	payload, err := p.encode()
+	if err != nil {
+		return err
+	}
+	if ext != nil {
+		if err := e.writeChunk(extChunkID, ext); err != nil {
 			return err
 		}
 	}
+	return e.writeChunk(chunkID, payload)
+}
+
+func (e *Encoder) writeChunk(id string, payload []byte) error {
+	c := chunkHeader{Length: uint64(len(payload))}
+	copy(c.ID[:], id)
+	if err := binary.Write(e.w, binary.BigEndian, c); err != nil {
+		return err
+	}
+	_, err := e.w.Write(payload)
+	return err
+}
+
+// WriteHeader starts a chunk holding the settings of a pattern, whose
+// tracks are written one at a time by WriteTrack, after finishing any
+// chunk started before. The pattern's Tracks and Trailing bytes
+// are not written.
+//
+// The chunk is kept in memory until Flush writes it, unless the
+// encoder's Backfill is set and its output stream can seek.
+func (e *Encoder) WriteHeader(p Pattern) error {
+	if err := e.Flush(); err != nil {
+		return err
+	}
+	p.Tracks = nil
+	ext, err := p.extension()
+	if err != nil {
+		return err
+	}
+	payload, err := p.encode()
+	if err != nil {
+		return err
+	}
+	if ext != nil {
+		if err := e.writeChunk(extChunkID, ext); err != nil {
+			return err
+		}
+	}
+	e.seeker, e.payload = nil, nil
+	if s, ok := e.w.(io.WriteSeeker); ok && e.Backfill {
+		// Seeking fails on writers such as pipes.
+		if start, err := s.Seek(0, io.SeekCurrent); err == nil {
+			e.seeker, e.start = s, start
+		}
+	}
+	if e.seeker == nil {
+		e.payload = bytes.NewBuffer(payload)
+	} else if err := e.writeChunk(chunkID, payload); err != nil {
+		return err
+	}
+	e.steps, e.tracks, e.length = p.Steps(), 0, uint64(len(payload))
+	return nil
+}
+
+// WriteTrack writes a track of the chunk started by WriteHeader. Tracks with
+// step details cannot be written, as they belong in the extension chunk
+// preceding the chunk.
+func (e *Encoder) WriteTrack(t Track) error {
+	if e.steps == 0 {
+		return errors.New("drum: WriteTrack called without WriteHeader")
+	}
+	if t.Steps != nil {
+		return fmt.Errorf("drum: step details of track %q cannot be written by WriteTrack", t.Name)
+	}
+	if err := t.validate(e.tracks, e.steps); err != nil {
+		return err
+	}
+	b := t.encode()
+	if e.seeker == nil {
+		e.payload.Write(b)
+	} else if _, err := e.w.Write(b); err != nil {
+		return err
+	}
+	e.tracks++
+	e.length += uint64(len(b))
 	return nil
 }
+
+// Flush finishes the chunk started by WriteHeader, if any,
+// writing its length or the whole chunk.
+func (e *Encoder) Flush() error {
+	if e.steps == 0 {
+		return nil
+	}
+	e.steps = 0
+	if e.seeker == nil {
+		payload := e.payload.Bytes()
+		e.payload = nil
+		return e.writeChunk(chunkID, payload)
+	}
+	end, err := e.seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := e.seeker.Seek(e.start+int64(len(chunkID)), io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(e.seeker, binary.BigEndian, e.length); err != nil {
+		return err
+	}
+	_, err = e.seeker.Seek(end, io.SeekStart)
+	return err
+}
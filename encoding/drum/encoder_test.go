@@ -2,8 +2,12 @@ package drum
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"path"
+	"path/filepath"
 	"testing"
 )
 
@@ -30,8 +34,23 @@ func TestEncodeTrack(t *testing.T) {
 	}
 }
 
+func TestEncodeWideTrackID(t *testing.T) {
+	expected := Track{ID: 70000, Name: "tabla", Sequence: make([]byte, 16)}
+	p := Pattern{HardwareVersion: "0.909", Tempo: 120, Tracks: Tracks{expected}}
+	b := new(bytes.Buffer)
+	if err := NewEncoder(b).Encode(p); err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewPattern()
+	if err := NewDecoder(b).Decode(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Tracks) != 1 || decoded.Tracks[0].ID != expected.ID {
+		t.Fatalf("Expected track ID %v but received %v", expected.ID, decoded.Tracks)
+	}
+}
+
 func TestEncode(t *testing.T) {
-	unknownIndexes := map[int]struct{}{13: {}, 45: {}, 46: {}, 49: {}}
 	tData := []struct {
 		path   string
 		backup string
@@ -65,6 +84,15 @@ Tempo: 118
 (5) low-tom	|----|---x|----|----|
 (12) mid-tom	|----|----|x---|----|
 (9) hi-tom	|----|----|-x--|----|
+`,
+		},
+		{"pattern_4.splice",
+			`Saved with HW Version: 0.909
+Tempo: 240
+(0) SubKick	|----|----|----|----|
+(1) Kick	|x---|----|x---|----|
+(99) Maracas	|x-x-|x-x-|x-x-|x-x-|
+(255) Low Conga	|----|x---|----|x---|
 `,
 		},
 	}
@@ -87,12 +115,471 @@ Tempo: 118
 			t.Fatalf("Expected %v output bytes and got %v", len(expected), len(actual))
 		}
 		for i, b := range actual {
-			if _, ok := unknownIndexes[i]; ok {
-				continue
-			}
 			if expected[i] != b {
 				t.Fatalf("Expected '%v' byte but received '%v' at %v", expected[i], b, i)
 			}
 		}
 	}
 }
+
+func TestEncodeRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob(path.Join("patterns", "*.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range paths {
+		expected, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pattern, err := DecodeFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := new(bytes.Buffer)
+		if err := NewEncoder(b).Encode(*pattern); err != nil {
+			t.Fatalf("Something went wrong encoding %v - %v", p, err)
+		}
+		if !bytes.Equal(expected, b.Bytes()) {
+			t.Fatalf("%v wasn't re-encoded byte for byte.\nGot:\n% x\nExpected:\n% x",
+				p, b.Bytes(), expected)
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	tData := []struct {
+		name    string
+		pattern Pattern
+	}{
+		{"long version", Pattern{HardwareVersion: "0.808-alpha-with-a-rather-long-suffix"}},
+		{"NUL in version", Pattern{HardwareVersion: "0.808\x00alpha"}},
+		{"long name", Pattern{Tracks: Tracks{{Name: string(make([]byte, 256)), Sequence: make([]byte, 16)}}}},
+		{"short sequence", Pattern{Tracks: Tracks{{Name: "kick", Sequence: make([]byte, 15)}}}},
+	}
+	for _, input := range tData {
+		b := new(bytes.Buffer)
+		if err := NewEncoder(b).Encode(input.pattern); err == nil {
+			t.Fatalf("Expected an error encoding a pattern with a %v", input.name)
+		}
+		if b.Len() != 0 {
+			t.Fatalf("Expected no output encoding a pattern with a %v but received %v bytes",
+				input.name, b.Len())
+		}
+	}
+}
+
+func TestEncodeReserved(t *testing.T) {
+	b, err := ioutil.ReadFile(path.Join("patterns", "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Scribble over the padding of the hardware version field.
+	expected := append([]byte{}, b...)
+	copy(expected[14+len("0.808-alpha")+1:], "fw-build-1234")
+	p := NewPattern()
+	if err := NewDecoder(bytes.NewReader(expected)).Decode(p); err != nil {
+		t.Fatal(err)
+	}
+	if p.HardwareVersion != "0.808-alpha" {
+		t.Fatalf("wrong version - %v", p.HardwareVersion)
+	}
+	actual := new(bytes.Buffer)
+	if err := NewEncoder(actual).Encode(*p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual.Bytes()) {
+		t.Fatalf("Reserved bytes weren't re-encoded.\nGot:\n% x\nExpected:\n% x",
+			actual.Bytes(), expected)
+	}
+}
+
+func TestEncodeTimeSignature(t *testing.T) {
+	for _, sig := range []TimeSignature{
+		{StepsPerBeat: 3, BeatsPerBar: 4, Bars: 1},
+		{StepsPerBeat: 2, BeatsPerBar: 7, Bars: 1},
+		{StepsPerBeat: 4, BeatsPerBar: 4, Bars: 4},
+	} {
+		seq := make([]byte, sig.Steps())
+		for i := 0; i < len(seq); i += sig.StepsPerBeat {
+			seq[i] = 1
+		}
+		p := Pattern{HardwareVersion: "0.808-alpha", Tempo: 96, TimeSignature: sig,
+			Tracks: Tracks{{ID: 1, Name: "kick", Sequence: seq}}}
+		b := new(bytes.Buffer)
+		if err := NewEncoder(b).Encode(p); err != nil {
+			t.Fatal(err)
+		}
+		decoded := NewPattern()
+		if err := NewDecoder(b).Decode(decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.TimeSignature != sig {
+			t.Fatalf("Expected time signature %+v but received %+v", sig, decoded.TimeSignature)
+		}
+		if fmt.Sprint(decoded) != fmt.Sprint(p) {
+			t.Fatalf("Expected:\n%v\nReceived:\n%v", p, decoded)
+		}
+		parsed, err := NewPatternFromBackup(fmt.Sprint(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(parsed) != fmt.Sprint(p) {
+			t.Fatalf("Expected backup:\n%v\nReceived:\n%v", p, parsed)
+		}
+	}
+}
+
+func TestEncodeSwing(t *testing.T) {
+	backup := `Saved with HW Version: 0.808-alpha
+Tempo: 98
+Swing: 62%
+(0) kick	|x---|x---|x---|x---|
+(3) hh-open	|--x-|--x-|x-x-|--x-|
+`
+	p, err := NewPatternFromBackup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Swing != (Swing{Amount: 62}) {
+		t.Fatalf("Expected 62%% swing but received %+v", p.Swing)
+	}
+	b := new(bytes.Buffer)
+	if err := NewEncoder(b).Encode(*p); err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewPattern()
+	if err := NewDecoder(b).Decode(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.String() != backup {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", backup, decoded)
+	}
+	p.Swing.Amount = 90
+	if err := NewEncoder(ioutil.Discard).Encode(*p); err == nil {
+		t.Fatal("Expected an error encoding 90% swing")
+	}
+	if _, err := NewPatternFromBackup("Saved with HW Version: 0.808-alpha\nTempo: 98\n" +
+		"(0) kick\t|x---|x---|x---|x---|\nSwing: 62%\n"); err == nil {
+		t.Fatal("Expected an error parsing swing following tracks")
+	}
+}
+
+func TestEncodeUnknownExtension(t *testing.T) {
+	b, err := ioutil.ReadFile(path.Join("patterns", "pattern_2.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext := []byte{'S', 'P', 'L', 'E', 'X', 'T', 0, 0, 0, 0, 0, 0, 0, 7,
+		extVersion, 200, 3, 0, 'a', 'b', 'c'}
+	expected := append(ext, b...)
+	p := NewPattern()
+	if err := NewDecoder(bytes.NewReader(expected)).Decode(p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.Extensions, ext[15:]) {
+		t.Fatalf("Expected extensions % x but received % x", ext[15:], p.Extensions)
+	}
+	actual := new(bytes.Buffer)
+	if err := NewEncoder(actual).Encode(*p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual.Bytes()) {
+		t.Fatalf("Unknown extension wasn't re-encoded.\nGot:\n% x\nExpected:\n% x",
+			actual.Bytes(), expected)
+	}
+}
+
+func TestEncodeSteps(t *testing.T) {
+	backup := `Saved with HW Version: 0.909
+Tempo: 104
+(0) kick	|X---|x---|x---|x--o|
+(1) snare	|----|x---|----|x-x-|	4:p75 14:v20,t+30,r2
+(2) hh-close	|x-x-|x-x-|x-x-|x-x-|
+`
+	p, err := NewPatternFromBackup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := new(bytes.Buffer)
+	if err := NewEncoder(b).Encode(*p); err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewPattern()
+	if err := NewDecoder(b).Decode(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(decoded) != backup {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", backup, decoded)
+	}
+	if decoded.Tracks[2].Steps != nil {
+		t.Fatalf("Expected no step details for a plain track but received %v", decoded.Tracks[2].Steps)
+	}
+	if s := decoded.Tracks[1].Step(14); s != (Step{Velocity: 20, Probability: 100, Offset: 30, Ratchets: 2}) {
+		t.Fatalf("Unexpected step details %+v", s)
+	}
+
+	p.Tracks = p.Tracks[:1]
+	p.Tracks[0].Steps[4].Velocity = 0
+	if err := NewEncoder(new(bytes.Buffer)).Encode(*p); err == nil {
+		t.Fatal("Expected an error encoding a hit without velocity")
+	}
+}
+
+func TestEncodeFileRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob(path.Join("patterns", "*.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, p := range paths {
+		expected, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pattern, err := DecodeFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, filepath.Base(p))
+		if err := EncodeFile(out, *pattern); err != nil {
+			t.Fatalf("Something went wrong encoding %v - %v", p, err)
+		}
+		actual, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Fatalf("%v wasn't re-encoded byte for byte.\nGot:\n% x\nExpected:\n% x",
+				p, actual, expected)
+		}
+		decoded, err := DecodeFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.String() != pattern.String() {
+			t.Fatalf("%v decoded as:\n%v\nExpected:\n%v", out, decoded, pattern)
+		}
+	}
+}
+
+func TestEncodeFileReplace(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "pattern.splice")
+	first := Pattern{HardwareVersion: "0.808-alpha", Tempo: 120}
+	second := Pattern{HardwareVersion: "0.909", Tempo: 98.4}
+	fe := NewFileEncoder()
+	fe.Perm = 0600
+	fe.Backup = true
+	if err := fe.EncodeFile(out, first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out + ".bak"); !os.IsNotExist(err) {
+		t.Fatalf("Expected no backup of a created file, got %v", err)
+	}
+	if err := os.Chmod(out, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := fe.EncodeFile(out, second); err != nil {
+		t.Fatal(err)
+	}
+	for file, expected := range map[string]Pattern{out: second, out + ".bak": first} {
+		p, err := DecodeFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.String() != expected.String() {
+			t.Fatalf("%v decoded as:\n%v\nExpected:\n%v", file, p, expected)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0640 {
+			t.Fatalf("Expected %v to have permissions 0640, got %#o", file, perm)
+		}
+	}
+
+	// A failed encoding leaves the file as it was.
+	invalid := Pattern{HardwareVersion: "0.808-alpha-with-a-rather-long-suffix"}
+	if err := EncodeFile(out, invalid); err == nil {
+		t.Fatal("Expected an error encoding an invalid pattern")
+	}
+	p, err := DecodeFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != second.String() {
+		t.Fatalf("%v decoded after a failed encoding as:\n%v\nExpected:\n%v", out, p, second)
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hidden, err := filepath.Glob(filepath.Join(dir, ".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || len(hidden) != 0 {
+		t.Fatalf("Expected only the file and its backup in %v, found %v", dir, append(names, hidden...))
+	}
+}
+
+func TestEncodeFileSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.splice")
+	link := filepath.Join(dir, "link.splice")
+	if err := EncodeFile(target, Pattern{HardwareVersion: "0.808-alpha"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip(err)
+	}
+	expected := Pattern{HardwareVersion: "0.909", Tempo: 120}
+	if err := EncodeFile(link, expected); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Expected %v to remain a symbolic link", link)
+	}
+	p, err := DecodeFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != expected.String() {
+		t.Fatalf("%v decoded as:\n%v\nExpected:\n%v", target, p, expected)
+	}
+
+	// The target of a dangling link is created through a relative link.
+	dangling := filepath.Join(dir, "dangling.splice")
+	if err := os.Symlink("created.splice", dangling); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeFile(dangling, expected); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(dangling); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Expected %v to remain a symbolic link", dangling)
+	}
+	if p, err = DecodeFile(filepath.Join(dir, "created.splice")); err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != expected.String() {
+		t.Fatalf("The target of %v decoded as:\n%v\nExpected:\n%v", dangling, p, expected)
+	}
+}
+
+func TestEncodeTrackStream(t *testing.T) {
+	paths, err := filepath.Glob(path.Join("patterns", "*.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, p := range paths {
+		pattern, err := DecodeFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pattern.Trailing = nil
+		expected := new(bytes.Buffer)
+		if err := NewEncoder(expected).Encode(*pattern); err != nil {
+			t.Fatal(err)
+		}
+		// A buffer cannot seek, unlike a file, and a file opened for
+		// appending cannot be written at the offset it seeks to.
+		buffer := new(bytes.Buffer)
+		name := filepath.Join(dir, filepath.Base(p))
+		appended := name + ".append"
+		for _, out := range []struct {
+			w        io.Writer
+			backfill bool
+		}{{buffer, true}, {openFile(t, name, os.O_WRONLY|os.O_CREATE), true}, {openFile(t, appended, os.O_WRONLY|os.O_CREATE|os.O_APPEND), false}} {
+			e := NewEncoder(out.w)
+			e.Backfill = out.backfill
+			// Stream the pattern twice to follow a backfilled chunk.
+			for i := 0; i < 2; i++ {
+				if err := e.WriteHeader(*pattern); err != nil {
+					t.Fatal(err)
+				}
+				for _, track := range pattern.Tracks {
+					if err := e.WriteTrack(track); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := e.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		twice := bytes.Repeat(expected.Bytes(), 2)
+		streams := map[string][]byte{"a buffer": buffer.Bytes()}
+		for _, file := range []string{name, appended} {
+			if streams[file], err = ioutil.ReadFile(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for out, actual := range streams {
+			if !bytes.Equal(twice, actual) {
+				t.Fatalf("%v wasn't streamed byte for byte to %v.\nGot:\n% x\nExpected:\n% x",
+					p, out, actual, twice)
+			}
+			d := NewDecoder(bytes.NewReader(actual))
+			for d.More() {
+				decoded := NewPattern()
+				if err := d.Decode(decoded); err != nil {
+					t.Fatalf("Could not decode %v streamed to %v: %v", p, out, err)
+				}
+				if decoded.String() != pattern.String() {
+					t.Fatalf("%v streamed to %v decoded as:\n%v\nExpected:\n%v", p, out, decoded, pattern)
+				}
+			}
+		}
+	}
+}
+
+// openFile opens a file that is closed at the end of the test.
+func openFile(t *testing.T, name string, flag int) *os.File {
+	f, err := os.OpenFile(name, flag, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestEncodeTrackStreamInvalid(t *testing.T) {
+	b := new(bytes.Buffer)
+	e := NewEncoder(b)
+	if err := e.WriteTrack(Track{Sequence: make([]byte, 16)}); err == nil {
+		t.Fatal("Expected an error writing a track without a header")
+	}
+	if err := e.WriteHeader(Pattern{HardwareVersion: "0.808-alpha", Tempo: 120}); err != nil {
+		t.Fatal(err)
+	}
+	invalid := []Track{
+		{Name: "short", Sequence: make([]byte, 15)},
+		{Name: "details", Sequence: make([]byte, 16), Steps: make([]Step, 16)},
+	}
+	for _, track := range invalid {
+		if err := e.WriteTrack(track); err == nil {
+			t.Fatalf("Expected an error writing track %q", track.Name)
+		}
+	}
+	if err := e.WriteTrack(Track{ID: 1, Name: "kick", Sequence: make([]byte, 16)}); err != nil {
+		t.Fatal(err)
+	}
+	// Encode finishes the chunk first.
+	if err := e.Encode(Pattern{HardwareVersion: "0.909", Tempo: 98}); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(b)
+	for _, expected := range []int{1, 0} {
+		p := NewPattern()
+		if err := d.Decode(p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Tracks) != expected {
+			t.Fatalf("Expected %v tracks but decoded %v", expected, len(p.Tracks))
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package drum
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel causes of a *SyntaxError, for use with errors.Is.
+var (
+	// ErrBadMagic means a chunk did not open with the "SPLICE" identifier.
+	ErrBadMagic = errors.New("drum: bad chunk identifier")
+	// ErrTruncated means the input ended before a chunk was complete.
+	ErrTruncated = errors.New("drum: truncated chunk")
+	// ErrLengthMismatch means a chunk's declared length
+	// ended in the middle of a field.
+	ErrLengthMismatch = errors.New("drum: chunk length mismatch")
+	// ErrBadExtension means an extension chunk has an unsupported
+	// version or a malformed record.
+	ErrBadExtension = errors.New("drum: bad extension")
+	// ErrBadArrangement means an arrangement chunk has an unsupported
+	// version or a section playing a pattern the song does not have.
+	ErrBadArrangement = errors.New("drum: bad arrangement")
+)
+
+// A Field names the part of a chunk that was being decoded.
+type Field string
+
+// Fields of a chunk in the order they are decoded.
+const (
+	FieldExtension  Field = "extension"
+	FieldHeader     Field = "header"
+	FieldTrackID    Field = "track ID"
+	FieldNameLength Field = "name length"
+	FieldName       Field = "name"
+	FieldSteps      Field = "steps"
+	// FieldArrangement is the arrangement chunk concluding a song.
+	FieldArrangement Field = "arrangement"
+)
+
+// Parts of FieldHeader that a Decoder maps separately.
+// A *SyntaxError reports them as FieldHeader.
+const (
+	FieldChunkID     Field = "chunk ID"
+	FieldChunkLength Field = "chunk length"
+	FieldVersion     Field = "hardware version"
+	FieldTempo       Field = "tempo"
+)
+
+// A SyntaxError describes where a Decoder failed to decode its input.
+type SyntaxError struct {
+	Offset int64 // byte offset in the input stream at which Field starts
+	Field  Field
+	Track  int   // index of the track within the chunk, or -1 for the header
+	Chunk  int   // index of the chunk within the input stream
+	Err    error // ErrBadMagic, ErrTruncated, ErrLengthMismatch or a read error
+}
+
+func (e *SyntaxError) Error() string {
+	where := fmt.Sprintf("chunk %d", e.Chunk)
+	if e.Track >= 0 {
+		where += fmt.Sprintf(" track %d", e.Track)
+	}
+	return fmt.Sprintf("%v: %s %s at offset %d", e.Err, where, e.Field, e.Offset)
+}
+
+// Unwrap returns the cause of the error.
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// A ParseError describes where text in the backup format failed to parse.
+type ParseError struct {
+	Line   int // line number counted from 1
+	Column int // column in runes counted from 1
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("drum: line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
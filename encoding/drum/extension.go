@@ -0,0 +1,204 @@
+package drum
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// extChunkID marks an extension chunk. An extension chunk holds pattern
+// settings the original hardware format has no room for and immediately
+// precedes the pattern chunk it applies to.
+//
+// Its payload is a version byte followed by a series of records,
+// each made of a tag byte, a little-endian uint16 value length and the value.
+// Records with unknown tags are kept in Pattern.Extensions.
+const extChunkID = "SPLEXT"
+
+// extVersion is the version of the extension chunk layout.
+const extVersion = 1
+
+// Tags of extension records.
+const (
+	tagTimeSignature byte = 1 // steps per beat, beats per bar and bars as bytes
+	tagSteps         byte = 2 // uint32 track index, then velocity, probability, offset and ratchets of every step
+	tagSwing         byte = 3 // swing amount in percent and resolution as bytes
+)
+
+// A TimeSignature divides the steps of a pattern's tracks into beats and bars.
+// The zero value stands for DefaultTimeSignature.
+type TimeSignature struct {
+	StepsPerBeat int
+	BeatsPerBar  int
+	Bars         int
+}
+
+// DefaultTimeSignature is the single bar of four beats in four
+// sixteenth note steps that the original hardware format assumes.
+var DefaultTimeSignature = TimeSignature{StepsPerBeat: 4, BeatsPerBar: 4, Bars: 1}
+
+func (s TimeSignature) orDefault() TimeSignature {
+	if s == (TimeSignature{}) {
+		return DefaultTimeSignature
+	}
+	return s
+}
+
+// Steps returns the number of steps in each track of a pattern.
+func (s TimeSignature) Steps() int {
+	s = s.orDefault()
+	return s.StepsPerBeat * s.BeatsPerBar * s.Bars
+}
+
+func (s TimeSignature) validate() error {
+	s = s.orDefault()
+	for _, n := range []int{s.StepsPerBeat, s.BeatsPerBar, s.Bars} {
+		if n < 1 || n > 255 {
+			return fmt.Errorf("drum: time signature %+v is out of range", s)
+		}
+	}
+	return nil
+}
+
+// extension returns the payload of the extension chunk for the pattern,
+// or nil if the pattern needs no extension chunk.
+func (p Pattern) extension() ([]byte, error) {
+	var b []byte
+	if s := p.TimeSignature.orDefault(); s != DefaultTimeSignature {
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+		b = appendRecord(b, tagTimeSignature, []byte{byte(s.StepsPerBeat), byte(s.BeatsPerBar), byte(s.Bars)})
+	}
+	if p.Swing != (Swing{}) {
+		if err := p.Swing.validate(); err != nil {
+			return nil, err
+		}
+		b = appendRecord(b, tagSwing, []byte{byte(p.Swing.Amount), byte(p.Swing.Resolution)})
+	}
+	for i, t := range p.Tracks {
+		if t.Steps == nil {
+			continue
+		}
+		value := make([]byte, 4, 4+4*len(t.Steps))
+		binary.LittleEndian.PutUint32(value, uint32(i))
+		for _, s := range t.Steps {
+			value = append(value, s.Velocity, s.Probability, byte(s.Offset), s.Ratchets)
+		}
+		if len(value) > 0xffff {
+			return nil, fmt.Errorf("drum: track %d has too many step details to encode", i)
+		}
+		b = appendRecord(b, tagSteps, value)
+	}
+	b = append(b, p.Extensions...)
+	if len(b) == 0 {
+		return nil, nil
+	}
+	return append([]byte{extVersion}, b...), nil
+}
+
+func appendRecord(b []byte, tag byte, value []byte) []byte {
+	b = append(b, tag, 0, 0)
+	binary.LittleEndian.PutUint16(b[len(b)-2:], uint16(len(value)))
+	return append(b, value...)
+}
+
+// readExtension decodes the payload of an extension chunk into p.
+func (d *Decoder) readExtension(p *Pattern) error {
+	var version byte
+	if err := d.read(FieldExtension, -1, &version); err != nil {
+		return err
+	}
+	if version != extVersion {
+		return d.syntaxError(d.r.n-1, FieldExtension, -1, ErrBadExtension)
+	}
+	for d.payload.N > 0 {
+		offset := d.r.n
+		var tag byte
+		var n uint16
+		if err := d.read(FieldExtension, -1, &tag); err != nil {
+			return err
+		}
+		if err := d.read(FieldExtension, -1, &n); err != nil {
+			return err
+		}
+		value := make([]byte, n)
+		if err := d.read(FieldExtension, -1, &value); err != nil {
+			return err
+		}
+		switch tag {
+		case tagTimeSignature:
+			if n != 3 {
+				return d.syntaxError(offset, FieldExtension, -1, ErrBadExtension)
+			}
+			p.TimeSignature = TimeSignature{
+				StepsPerBeat: int(value[0]),
+				BeatsPerBar:  int(value[1]),
+				Bars:         int(value[2]),
+			}
+			if p.TimeSignature.validate() != nil {
+				return d.syntaxError(offset, FieldExtension, -1, ErrBadExtension)
+			}
+		case tagSwing:
+			if n != 2 {
+				return d.syntaxError(offset, FieldExtension, -1, ErrBadExtension)
+			}
+			p.Swing = Swing{Amount: int(value[0]), Resolution: int(value[1])}
+			if p.Swing.validate() != nil {
+				return d.syntaxError(offset, FieldExtension, -1, ErrBadExtension)
+			}
+		case tagSteps:
+			if n < 4 || (n-4)%4 != 0 {
+				return d.syntaxError(offset, FieldExtension, -1, ErrBadExtension)
+			}
+			details := stepDetails{offset: offset, chunk: d.chunk}
+			for v := value[4:]; len(v) > 0; v = v[4:] {
+				details.steps = append(details.steps, Step{v[0], v[1], int8(v[2]), v[3]})
+			}
+			d.steps[int(binary.LittleEndian.Uint32(value))] = details
+		default:
+			p.Extensions = appendRecord(p.Extensions, tag, value)
+		}
+	}
+	return nil
+}
+
+// stepDetails holds the steps of a track decoded from an extension record
+// until the track itself is decoded.
+type stepDetails struct {
+	offset int64 // offset of the extension record
+	chunk  int   // index of the extension chunk
+	steps  []Step
+}
+
+// attachSteps sets the steps of track i decoded from the extension chunk.
+func (d *Decoder) attachSteps(i int, t *Track) error {
+	details, ok := d.steps[i]
+	if !ok {
+		return nil
+	}
+	delete(d.steps, i)
+	t.Steps = details.steps
+	if t.validateSteps() != nil {
+		return details.syntaxError(i)
+	}
+	return nil
+}
+
+// unattachedSteps reports the step details decoded for the first of
+// the tracks that do not exist.
+func (d *Decoder) unattachedSteps() error {
+	first := -1
+	for i := range d.steps {
+		if first < 0 || i < first {
+			first = i
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	return d.steps[first].syntaxError(first)
+}
+
+func (s stepDetails) syntaxError(track int) error {
+	return &SyntaxError{Offset: s.offset, Field: FieldExtension, Track: track, Chunk: s.chunk, Err: ErrBadExtension}
+}
@@ -0,0 +1,88 @@
+package drum
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format returns the canonical formatting of a text backup read from r.
+// Every header and track line is rewritten as Pattern.String writes it,
+// keeping the order of the lines, and the grids of consecutive tracks are
+// aligned in a column. Comments are kept, runs of blank lines are reduced
+// to one, and blank lines opening or closing the backup are removed.
+// It returns a *ParseError if the text does not follow the backup format.
+func Format(r io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var lines []formatLine
+	p := NewPattern()
+	seen := make(map[string]bool)
+	blank := false
+	for i, line := range strings.Split(string(b), "\n") {
+		sc := &lineScanner{text: strings.TrimSuffix(line, "\r"), line: i + 1}
+		sc.skipBlanks()
+		if sc.done() {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, formatLine{})
+			blank = false
+		}
+		if sc.peek() == '#' {
+			lines = append(lines, formatLine{text: strings.TrimRight(sc.rest(), " \t\r")})
+			continue
+		}
+		tracks := len(p.Tracks)
+		text, err := p.parseLine(sc, seen)
+		if err != nil {
+			return nil, err
+		}
+		var l formatLine
+		if len(p.Tracks) > tracks {
+			// Split the track at the tab following its name, see Track.format.
+			t := p.Tracks[tracks]
+			l.name = fmt.Sprintf("(%d) %s", t.ID, t.Name)
+			text = strings.Replace(text[len(l.name)+1:], "\t", " ", 1)
+		}
+		l.text = text
+		if comment := strings.TrimRight(sc.rest(), " \t\r"); comment != "" {
+			l.text += " " + comment
+		}
+		lines = append(lines, l)
+	}
+	var out bytes.Buffer
+	for i := 0; i < len(lines); {
+		// Align the grids of a run of tracks.
+		j, width := i, 0
+		for ; j < len(lines) && lines[j].name != ""; j++ {
+			if n := utf8.RuneCountInString(lines[j].name); n > width {
+				width = n
+			}
+		}
+		for _, l := range lines[i:j] {
+			out.WriteString(l.name)
+			out.WriteString(strings.Repeat(" ", width-utf8.RuneCountInString(l.name)+1))
+			out.WriteString(l.text + "\n")
+		}
+		if j == i {
+			out.WriteString(lines[i].text + "\n")
+			j++
+		}
+		i = j
+	}
+	return out.Bytes(), nil
+}
+
+// A formatLine is a line of formatted backup text. The line of a
+// track is split into the ID and name, and the grid that follows.
+type formatLine struct {
+	name string
+	text string
+}
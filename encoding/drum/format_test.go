@@ -0,0 +1,90 @@
+package drum
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	input := `
+
+# A hand edited backup.
+Saved with HW Version:   0.808-alpha
+Tempo:120.0   # fast
+Steps: 4 per beat, 4 beats per bar,   1 bar
+
+
+
+(0)   kick |x---|x---|x---|x---|  # four on the floor
+(12) Low Conga	|x---|o---|X---|x---|    0:p50,r2
+	(3) hh |x-x-|x-x-|x-x-|x-x-|
+# Fills.
+(4294967295) hh-open	|--x-|--x-|x-x-|--x-|
+
+`
+	expected := `# A hand edited backup.
+Saved with HW Version: 0.808-alpha
+Tempo: 120 # fast
+Steps: 4 per beat, 4 beats per bar, 1 bars
+
+(0) kick       |x---|x---|x---|x---| # four on the floor
+(12) Low Conga |x---|o---|X---|x---| 0:p50,r2
+(3) hh         |x-x-|x-x-|x-x-|x-x-|
+# Fills.
+(4294967295) hh-open |--x-|--x-|x-x-|--x-|
+`
+	b, err := Format(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", expected, string(b))
+	}
+	again, err := Format(strings.NewReader(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != expected {
+		t.Fatalf("Expected formatting to be idempotent but received:\n%v", string(again))
+	}
+
+	crlf := "# Saved on Windows. \r  \r\nTempo: 120\r\n(0) kick |x---|x---|x---|x---| # four on the floor\r \r\n"
+	expected = "# Saved on Windows.\nTempo: 120\n(0) kick |x---|x---|x---|x---| # four on the floor\n"
+	b, err = Format(strings.NewReader(crlf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Fatalf("Expected carriage returns to be removed:\n%q\nReceived:\n%q", expected, string(b))
+	}
+
+	_, err = Format(strings.NewReader(input + "(5) snare |x---|\n"))
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 16 {
+		t.Fatalf("Expected an error at line 16 but received %v", err)
+	}
+}
+
+func TestFormatPatterns(t *testing.T) {
+	for _, name := range []string{"pattern_1.splice", "pattern_2.splice", "pattern_3.splice",
+		"pattern_4.splice", "pattern_5.splice"} {
+		p, err := DecodeFile(path.Join("patterns", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Format(strings.NewReader(p.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted, err := NewPatternFromBackup(string(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(formatted) != fmt.Sprint(p) {
+			t.Fatalf("Expected %v to format as:\n%v\nReceived:\n%v", name, p, formatted)
+		}
+	}
+}
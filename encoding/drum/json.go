@@ -0,0 +1,362 @@
+package drum
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+type patternDoc struct {
+	Version       string        `json:"version" yaml:"version"`
+	Tempo         float32       `json:"tempo" yaml:"tempo"`
+	Steps         int           `json:"steps,omitempty" yaml:"steps,omitempty"`
+	TimeSignature *signatureDoc `json:"timeSignature,omitempty" yaml:"timeSignature,omitempty"`
+	Swing         *swingDoc     `json:"swing,omitempty" yaml:"swing,omitempty"`
+	Tracks        []Track       `json:"tracks" yaml:"tracks"`
+	Reserved      string        `json:"reserved,omitempty" yaml:"reserved,omitempty"`
+	Trailing      string        `json:"trailing,omitempty" yaml:"trailing,omitempty"`
+	Extensions    string        `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+}
+
+type signatureDoc struct {
+	StepsPerBeat int `json:"stepsPerBeat" yaml:"stepsPerBeat"`
+	BeatsPerBar  int `json:"beatsPerBar" yaml:"beatsPerBar"`
+	Bars         int `json:"bars" yaml:"bars"`
+}
+
+type swingDoc struct {
+	Amount     int `json:"amount" yaml:"amount"`
+	Resolution int `json:"resolution,omitempty" yaml:"resolution,omitempty"`
+}
+
+type trackDoc struct {
+	ID   uint32 `json:"id" yaml:"id"`
+	Name string `json:"name" yaml:"name"`
+	// Steps is a string or an array of numbers and stepDocs.
+	Steps interface{} `json:"steps" yaml:"steps"`
+}
+
+type stepDoc struct {
+	Velocity    uint8 `json:"velocity" yaml:"velocity"`
+	Probability uint8 `json:"probability" yaml:"probability"`
+	Offset      int8  `json:"offset" yaml:"offset"`
+	Ratchets    uint8 `json:"ratchets" yaml:"ratchets"`
+}
+
+func (p Pattern) document() patternDoc {
+	doc := patternDoc{
+		Version:    p.HardwareVersion,
+		Tempo:      p.Tempo,
+		Steps:      p.Steps(),
+		Tracks:     p.Tracks,
+		Reserved:   base64.StdEncoding.EncodeToString(p.Reserved),
+		Trailing:   base64.StdEncoding.EncodeToString(p.Trailing),
+		Extensions: base64.StdEncoding.EncodeToString(p.Extensions),
+	}
+	if doc.Tracks == nil {
+		doc.Tracks = Tracks{}
+	}
+	if s := p.TimeSignature.orDefault(); s != DefaultTimeSignature {
+		doc.TimeSignature = &signatureDoc{s.StepsPerBeat, s.BeatsPerBar, s.Bars}
+	}
+	if p.Swing != (Swing{}) {
+		doc.Swing = &swingDoc{p.Swing.Amount, p.Swing.Resolution}
+	}
+	return doc
+}
+
+func (p *Pattern) setDocument(doc patternDoc) error {
+	q := Pattern{HardwareVersion: doc.Version, Tempo: doc.Tempo, Tracks: doc.Tracks}
+	if doc.TimeSignature != nil {
+		s := doc.TimeSignature
+		q.TimeSignature = TimeSignature{s.StepsPerBeat, s.BeatsPerBar, s.Bars}
+		if err := q.TimeSignature.validate(); err != nil {
+			return err
+		}
+	}
+	if doc.Swing != nil {
+		q.Swing = Swing{doc.Swing.Amount, doc.Swing.Resolution}
+		if err := q.Swing.validate(); err != nil {
+			return err
+		}
+	}
+	if doc.Steps != 0 && doc.Steps != q.Steps() {
+		return fmt.Errorf("drum: %d steps disagree with time signature %+v", doc.Steps, q.TimeSignature.orDefault())
+	}
+	if q.Tracks == nil {
+		q.Tracks = make([]Track, 0)
+	}
+	for i, t := range q.Tracks {
+		if len(t.Sequence) != q.Steps() {
+			return fmt.Errorf("drum: track %d has %d steps instead of %d", i, len(t.Sequence), q.Steps())
+		}
+	}
+	for _, field := range []struct {
+		b   *[]byte
+		doc string
+	}{{&q.Reserved, doc.Reserved}, {&q.Trailing, doc.Trailing}, {&q.Extensions, doc.Extensions}} {
+		b, err := base64.StdEncoding.DecodeString(field.doc)
+		if err != nil {
+			return fmt.Errorf("drum: %v", err)
+		}
+		if len(b) > 0 {
+			*field.b = b
+		}
+	}
+	*p = q
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+// A pattern is marshaled to a document of the form
+//
+//	{
+//	  "version": "0.808-alpha",
+//	  "tempo": 120,
+//	  "steps": 16,
+//	  "timeSignature": {"stepsPerBeat": 4, "beatsPerBar": 4, "bars": 1},
+//	  "swing": {"amount": 62, "resolution": 16},
+//	  "tracks": [
+//	    {"id": 0, "name": "kick", "steps": "x---x---X---x--o"},
+//	    {"id": 1, "name": "snare", "steps": [0, 0, 0, 0, {"velocity": 90, ...}, ...]}
+//	  ]
+//	}
+//
+// where "timeSignature" and "swing" are omitted unless they are set, and
+// "steps" counts the steps of every track. When unmarshaling, "steps" may
+// be omitted but must otherwise agree with the time signature.
+//
+// The steps of a track are written as a string of the characters of the
+// text backup format: x for a hit, X for an accent, o for a ghost note and
+// - for a rest. When unmarshaling, they may be grouped by | separators
+// and spaces. A track with step details the characters cannot express is
+// written as an array instead, of the hardware values of its steps, 0 for
+// a rest and 1 for a hit, and objects holding the velocity, probability,
+// offset and ratchets of hits. Details missing from an object default to
+// those of DefaultStep.
+//
+// The bytes of Reserved, Trailing and Extensions are written base64
+// encoded as "reserved", "trailing" and "extensions" if there are any.
+func (p Pattern) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.document())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *Pattern) UnmarshalJSON(b []byte) error {
+	var doc patternDoc
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	return p.setDocument(doc)
+}
+
+// MarshalYAML returns the document a YAML encoder writes for the pattern,
+// in the manner of the Marshaler interface of gopkg.in/yaml.v2.
+// The document has the same form as the one written by MarshalJSON.
+func (p Pattern) MarshalYAML() (interface{}, error) {
+	return p.document(), nil
+}
+
+// UnmarshalYAML initializes the pattern from the document it is given
+// by a YAML decoder, in the manner of the Unmarshaler interface of
+// gopkg.in/yaml.v2.
+func (p *Pattern) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var doc patternDoc
+	if err := unmarshal(&doc); err != nil {
+		return err
+	}
+	return p.setDocument(doc)
+}
+
+func (t Track) document() trackDoc {
+	doc := trackDoc{ID: t.ID, Name: t.Name}
+	var b strings.Builder
+	for i, v := range t.Sequence {
+		if v > 1 {
+			doc.Steps = t.stepArray()
+			return doc
+		}
+		if v == 0 {
+			b.WriteRune(offBeat)
+			continue
+		}
+		r, annotate := beatRune(t.Step(i))
+		if annotate {
+			doc.Steps = t.stepArray()
+			return doc
+		}
+		b.WriteRune(r)
+	}
+	doc.Steps = b.String()
+	return doc
+}
+
+// stepArray returns the steps of the track as an array of
+// hardware step values and the details of hits.
+func (t Track) stepArray() []interface{} {
+	steps := make([]interface{}, len(t.Sequence))
+	for i, v := range t.Sequence {
+		steps[i] = v
+		if s := t.Step(i); v == 1 && s != DefaultStep {
+			steps[i] = stepDoc{s.Velocity, s.Probability, s.Offset, s.Ratchets}
+		}
+	}
+	return steps
+}
+
+func (t *Track) setDocument(doc trackDoc) error {
+	u := Track{ID: doc.ID, Name: doc.Name}
+	if len(u.Name) > maxNameLen {
+		return fmt.Errorf("drum: track name %q exceeds %d bytes", u.Name, maxNameLen)
+	}
+	switch steps := doc.Steps.(type) {
+	case string:
+		var parsed []Step
+		for _, r := range steps {
+			switch r {
+			case onBeat, accentBeat, ghostBeat:
+				parsed = append(parsed, runeStep(r))
+			case offBeat:
+				parsed = append(parsed, Step{})
+			case separator, ' ':
+			default:
+				return fmt.Errorf("drum: track %q has unknown step %q", u.Name, r)
+			}
+		}
+		u.setSteps(parsed)
+	case []interface{}:
+		u.Sequence = make([]byte, len(steps))
+		details := make([]Step, len(steps))
+		plain := true
+		for i, v := range steps {
+			n, s, err := parseStepValue(v)
+			if err != nil {
+				return fmt.Errorf("drum: step %d of track %q: %v", i, u.Name, err)
+			}
+			u.Sequence[i], details[i] = n, s
+			if n == 1 && s != DefaultStep {
+				plain = false
+			}
+		}
+		if !plain {
+			u.Steps = details
+		}
+		if err := u.validateSteps(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("drum: track %q has steps of type %T", u.Name, doc.Steps)
+	}
+	*t = u
+	return nil
+}
+
+// parseStepValue interprets an element of a step array as decoded
+// into an interface{} by a JSON or YAML decoder.
+func parseStepValue(v interface{}) (value byte, s Step, err error) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, DefaultStep, nil
+		}
+		return 0, Step{}, nil
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, s, fmt.Errorf("%v is not a step value", v)
+		}
+		return parseStepValue(int(v))
+	case int:
+		if v < 0 || v > 255 {
+			return 0, s, fmt.Errorf("%v is not a step value", v)
+		}
+		if v == 1 {
+			s = DefaultStep
+		}
+		return byte(v), s, nil
+	case map[string]interface{}:
+		s = DefaultStep
+		for k, field := range v {
+			if err := setStepField(&s, k, field); err != nil {
+				return 0, s, err
+			}
+		}
+		return 1, s, s.validate()
+	case map[interface{}]interface{}:
+		s = DefaultStep
+		for k, field := range v {
+			if err := setStepField(&s, fmt.Sprint(k), field); err != nil {
+				return 0, s, err
+			}
+		}
+		return 1, s, s.validate()
+	}
+	return 0, s, fmt.Errorf("%v is not a step value", v)
+}
+
+func setStepField(s *Step, key string, v interface{}) error {
+	var n int
+	switch v := v.(type) {
+	case float64:
+		n = int(v)
+		if float64(n) != v {
+			return fmt.Errorf("%s %v is not an integer", key, v)
+		}
+	case int:
+		n = v
+	default:
+		return fmt.Errorf("%s %v is not an integer", key, v)
+	}
+	lo, hi := 0, 255
+	if key == "offset" {
+		lo, hi = -128, 127
+	}
+	if n < lo || n > hi {
+		return fmt.Errorf("%s %d is out of range", key, n)
+	}
+	switch key {
+	case "velocity":
+		s.Velocity = uint8(n)
+	case "probability":
+		s.Probability = uint8(n)
+	case "offset":
+		s.Offset = int8(n)
+	case "ratchets":
+		s.Ratchets = uint8(n)
+	default:
+		return fmt.Errorf("unknown step detail %q", key)
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (t Track) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.document())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *Track) UnmarshalJSON(b []byte) error {
+	var doc trackDoc
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	return t.setDocument(doc)
+}
+
+// MarshalYAML returns the document a YAML encoder writes for the track,
+// in the manner of the Marshaler interface of gopkg.in/yaml.v2.
+func (t Track) MarshalYAML() (interface{}, error) {
+	return t.document(), nil
+}
+
+// UnmarshalYAML initializes the track from the document it is given
+// by a YAML decoder, in the manner of the Unmarshaler interface of
+// gopkg.in/yaml.v2.
+func (t *Track) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var doc trackDoc
+	if err := unmarshal(&doc); err != nil {
+		return err
+	}
+	return t.setDocument(doc)
+}
@@ -0,0 +1,136 @@
+package drum
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	p := Pattern{HardwareVersion: "0.808-alpha", Tempo: 98.4, Swing: Swing{Amount: 62},
+		Tracks: Tracks{{ID: 0, Name: "kick"}, {ID: 40, Name: "snare"}}}
+	p.Tracks[0].setSteps([]Step{DefaultStep, {}, {}, {}, runeStep(accentBeat), {}, {}, {}, DefaultStep, {}, {}, {}, DefaultStep, {}, {}, runeStep(ghostBeat)})
+	p.Tracks[1].Sequence = make([]byte, 16)
+	p.Tracks[1].Sequence[4] = 1
+	p.Tracks[1].Steps = make([]Step, 16)
+	p.Tracks[1].Steps[4] = Step{Velocity: 90, Probability: 50, Offset: -12, Ratchets: 2}
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":"0.808-alpha","tempo":98.4,"steps":16,"swing":{"amount":62},"tracks":[` +
+		`{"id":0,"name":"kick","steps":"x---X---x---x--o"},` +
+		`{"id":40,"name":"snare","steps":[0,0,0,0,{"velocity":90,"probability":50,"offset":-12,"ratchets":2},0,0,0,0,0,0,0,0,0,0,0]}]}`
+	if string(b) != expected {
+		t.Fatalf("Expected:\n%s\nReceived:\n%s", expected, b)
+	}
+	var decoded Pattern
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, p) {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", p, decoded)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, name := range []string{"pattern_1.splice", "pattern_2.splice", "pattern_3.splice",
+		"pattern_4.splice", "pattern_5.splice"} {
+		p, err := DecodeFile(path.Join("patterns", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := NewPattern()
+		if err := json.Unmarshal(b, decoded); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, p) {
+			t.Fatalf("Expected %v to be unmarshaled as:\n%v\nReceived:\n%v", name, p, decoded)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	doc := `{"version": "0.909", "tempo": 120,
+		"timeSignature": {"stepsPerBeat": 3, "beatsPerBar": 2, "bars": 1},
+		"tracks": [
+			{"id": 1, "name": "kick", "steps": "|x--|X--|"},
+			{"id": 2, "name": "hh-close", "steps": [true, false, 1, 0, {"ratchets": 3}, 0]}
+		]}`
+	var p Pattern
+	if err := json.Unmarshal([]byte(doc), &p); err != nil {
+		t.Fatal(err)
+	}
+	expected := `Saved with HW Version: 0.909
+Tempo: 120
+Steps: 3 per beat, 2 beats per bar, 1 bars
+(1) kick	|x--|X--|
+(2) hh-close	|x-x|-x-|	4:r3
+`
+	if p.String() != expected {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", expected, p)
+	}
+
+	for _, doc := range []string{
+		`{"version": "0.909", "tempo": 120, "steps": 12, "tracks": []}`,
+		`{"version": "0.909", "tempo": 120, "tracks": [{"id": 1, "name": "kick", "steps": "x---"}]}`,
+		`{"version": "0.909", "tempo": 120, "tracks": [{"id": 1, "name": "kick", "steps": "x---x---x---x--y"}]}`,
+		`{"version": "0.909", "tempo": 120, "tracks": [{"id": 1, "name": "kick", "steps": 16}]}`,
+		`{"version": "0.909", "tempo": 120, "tracks": [{"id": 1, "name": "kick", "steps": [0.5]}]}`,
+		`{"version": "0.909", "tempo": 120, "tracks": [{"id": 1, "name": "kick", "steps": [{"velocity": 0}]}]}`,
+		`{"version": "0.909", "tempo": 120, "tracks": [{"id": 1, "name": "kick", "steps": [{"loudness": 1}]}]}`,
+		`{"version": "0.909", "tempo": 120, "swing": {"amount": 90}, "tracks": []}`,
+		`{"version": "0.909", "tempo": 120, "reserved": "!", "tracks": []}`,
+	} {
+		if err := json.Unmarshal([]byte(doc), new(Pattern)); err == nil {
+			t.Fatalf("Expected an error unmarshaling %v", doc)
+		}
+	}
+}
+
+func TestYAML(t *testing.T) {
+	p, err := DecodeFile(path.Join("patterns", "pattern_2.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := p.MarshalYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc.(patternDoc); !ok {
+		t.Fatalf("Expected a pattern document but received %T", doc)
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Pattern
+	unmarshal := func(v interface{}) error { return json.Unmarshal(b, v) }
+	if err := decoded.UnmarshalYAML(unmarshal); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(decoded) != fmt.Sprint(p) {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", p, decoded)
+	}
+
+	// YAML decoders produce ints and maps keyed by interface{}.
+	var track Track
+	unmarshal = func(v interface{}) error {
+		*v.(*trackDoc) = trackDoc{ID: 3, Name: "clap", Steps: []interface{}{
+			0, 1, map[interface{}]interface{}{"velocity": 20, "offset": -3}, 0}}
+		return nil
+	}
+	if err := track.UnmarshalYAML(unmarshal); err != nil {
+		t.Fatal(err)
+	}
+	steps := []Step{{}, DefaultStep, {Velocity: 20, Probability: 100, Offset: -3, Ratchets: 1}, {}}
+	if !reflect.DeepEqual(track.Steps, steps) || !reflect.DeepEqual(track.Sequence, []byte{0, 1, 1, 0}) {
+		t.Fatalf("Expected steps %+v but received %+v", steps, track.Steps)
+	}
+}
@@ -0,0 +1,264 @@
+package drum
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// A Conflict is a part of a pattern that both sides of a merge changed
+// differently. Ours and Theirs hold the values of the two sides, written
+// like the values of a Change, and are "" for a removed track.
+type Conflict struct {
+	Kind   ChangeKind
+	Track  uint32 // ID of the track of track and step conflicts
+	Step   int    // index of the step of step conflicts
+	Ours   string
+	Theirs string
+}
+
+func (c Conflict) String() string {
+	switch c.Kind {
+	case ChangeTrackRemoved, ChangeTrackAdded, ChangeTrackRenamed:
+		return fmt.Sprintf("track %d: %q <> %q", c.Track, c.Ours, c.Theirs)
+	case ChangeStep:
+		return fmt.Sprintf("track %d step %d: %s <> %s", c.Track, c.Step, c.Ours, c.Theirs)
+	}
+	return fmt.Sprintf("%s: %s <> %s", c.Kind, c.Ours, c.Theirs)
+}
+
+// Merge merges the changes turning base into ours and into theirs.
+// The header settings, the names of tracks and every step are merged
+// separately, with tracks matched by ID as by Diff, so that both sides
+// may change different steps of a track. Tracks added by ours are kept in
+// place and tracks added by theirs follow them.
+//
+// A change made by one side only is taken. A change made by both sides is
+// a conflict unless they agree, as is a track removed by one side and
+// changed by the other. Conflicts are resolved in favor of ours.
+func Merge(base, ours, theirs *Pattern) (*Pattern, []Conflict) {
+	m := &merger{}
+	p := NewPattern()
+	p.HardwareVersion = m.version(base.HardwareVersion, ours.HardwareVersion, theirs.HardwareVersion)
+	p.Tempo = m.tempo(base.Tempo, ours.Tempo, theirs.Tempo)
+	p.TimeSignature = m.signature(base.TimeSignature, ours.TimeSignature, theirs.TimeSignature)
+	p.Swing = m.swing(base.Swing, ours.Swing, theirs.Swing)
+	// Unknown bytes are merged whole, without reporting conflicts.
+	p.Reserved = mergeBytes(base.Reserved, ours.Reserved, theirs.Reserved)
+	p.Trailing = mergeBytes(base.Trailing, ours.Trailing, theirs.Trailing)
+	p.Extensions = mergeBytes(base.Extensions, ours.Extensions, theirs.Extensions)
+
+	oursBase := matchTracks(base.Tracks, ours.Tracks)
+	theirsBase := matchTracks(base.Tracks, theirs.Tracks)
+	inTheirs := make(map[int]int)
+	for j, i := range theirsBase {
+		if i >= 0 {
+			inTheirs[i] = j
+		}
+	}
+	inOurs := make(map[int]bool)
+	steps := p.Steps()
+	for j, t := range ours.Tracks {
+		i := oursBase[j]
+		if i < 0 {
+			p.Tracks = append(p.Tracks, m.added(t, theirs.Tracks, theirsBase, steps))
+			continue
+		}
+		inOurs[i] = true
+		b := base.Tracks[i]
+		k, ok := inTheirs[i]
+		if !ok {
+			// Theirs removed the track.
+			if !equalTracks(b, t) {
+				m.conflict(Conflict{Kind: ChangeTrackRemoved, Track: t.ID, Ours: t.Name})
+				p.Tracks = append(p.Tracks, resize(t, steps))
+			}
+			continue
+		}
+		p.Tracks = append(p.Tracks, m.track(b, t, theirs.Tracks[k], steps))
+	}
+	for i, b := range base.Tracks {
+		k, ok := inTheirs[i]
+		if inOurs[i] || !ok {
+			continue
+		}
+		// Ours removed the track.
+		if t := theirs.Tracks[k]; !equalTracks(b, t) {
+			m.conflict(Conflict{Kind: ChangeTrackRemoved, Track: t.ID, Theirs: t.Name})
+		}
+	}
+	for j, t := range theirs.Tracks {
+		if theirsBase[j] < 0 && !m.addedByOurs(t, ours.Tracks, oursBase) {
+			p.Tracks = append(p.Tracks, resize(t, steps))
+		}
+	}
+	return p, m.conflicts
+}
+
+// A merger collects the conflicts of a merge.
+type merger struct {
+	conflicts []Conflict
+}
+
+func (m *merger) conflict(c Conflict) {
+	m.conflicts = append(m.conflicts, c)
+}
+
+// merge3 reports which side of a three-way merge of a value to take,
+// and whether the sides conflict.
+func merge3(baseOurs, baseTheirs, oursTheirs bool) (takeOurs, conflict bool) {
+	switch {
+	case baseTheirs || oursTheirs:
+		return true, false
+	case baseOurs:
+		return false, false
+	}
+	return true, true
+}
+
+func (m *merger) version(base, ours, theirs string) string {
+	takeOurs, conflict := merge3(base == ours, base == theirs, ours == theirs)
+	if conflict {
+		m.conflict(Conflict{Kind: ChangeVersion, Ours: strconv.Quote(ours), Theirs: strconv.Quote(theirs)})
+	}
+	if takeOurs {
+		return ours
+	}
+	return theirs
+}
+
+func (m *merger) tempo(base, ours, theirs float32) float32 {
+	takeOurs, conflict := merge3(base == ours, base == theirs, ours == theirs)
+	if conflict {
+		m.conflict(Conflict{Kind: ChangeTempo, Ours: fmt.Sprint(ours), Theirs: fmt.Sprint(theirs)})
+	}
+	if takeOurs {
+		return ours
+	}
+	return theirs
+}
+
+func (m *merger) signature(base, ours, theirs TimeSignature) TimeSignature {
+	base, ours, theirs = base.orDefault(), ours.orDefault(), theirs.orDefault()
+	takeOurs, conflict := merge3(base == ours, base == theirs, ours == theirs)
+	if conflict {
+		m.conflict(Conflict{Kind: ChangeTimeSignature,
+			Ours:   Pattern{TimeSignature: ours}.signatureLine()[len(signaturePrefix):],
+			Theirs: Pattern{TimeSignature: theirs}.signatureLine()[len(signaturePrefix):]})
+	}
+	if takeOurs {
+		return ours
+	}
+	return theirs
+}
+
+func (m *merger) swing(base, ours, theirs Swing) Swing {
+	takeOurs, conflict := merge3(base == ours, base == theirs, ours == theirs)
+	if conflict {
+		m.conflict(Conflict{Kind: ChangeSwing, Ours: ours.String(), Theirs: theirs.String()})
+	}
+	if takeOurs {
+		return ours
+	}
+	return theirs
+}
+
+func mergeBytes(base, ours, theirs []byte) []byte {
+	if takeOurs, _ := merge3(bytes.Equal(base, ours), bytes.Equal(base, theirs), bytes.Equal(ours, theirs)); takeOurs {
+		return ours
+	}
+	return theirs
+}
+
+// track merges the name and steps of a track both sides kept.
+// Steps past the end of a track are taken as rests.
+func (m *merger) track(base, ours, theirs Track, steps int) Track {
+	t := Track{ID: ours.ID}
+	takeOurs, conflict := merge3(base.Name == ours.Name, base.Name == theirs.Name, ours.Name == theirs.Name)
+	t.Name = theirs.Name
+	if takeOurs {
+		t.Name = ours.Name
+	}
+	if conflict {
+		m.conflict(Conflict{Kind: ChangeTrackRenamed, Track: t.ID, Ours: ours.Name, Theirs: theirs.Name})
+	}
+	t.Sequence = make([]byte, steps)
+	details := make([]Step, steps)
+	for i := range t.Sequence {
+		b, o, th := base.stepText(i), ours.stepText(i), theirs.stepText(i)
+		takeOurs, conflict := merge3(b == o, b == th, o == th)
+		if conflict {
+			m.conflict(Conflict{Kind: ChangeStep, Track: t.ID, Step: i, Ours: o, Theirs: th})
+		}
+		from := theirs
+		if takeOurs {
+			from = ours
+		}
+		t.Sequence[i], details[i] = from.stepAt(i)
+	}
+	t.setDetails(details)
+	return t
+}
+
+// added returns a track ours added, reporting a conflict if
+// theirs added a different track with the same ID.
+func (m *merger) added(t Track, theirs []Track, theirsBase []int, steps int) Track {
+	for j, u := range theirs {
+		if theirsBase[j] < 0 && u.ID == t.ID && !equalTracks(t, u) {
+			m.conflict(Conflict{Kind: ChangeTrackAdded, Track: t.ID, Ours: t.Name, Theirs: u.Name})
+			break
+		}
+	}
+	return resize(t, steps)
+}
+
+// addedByOurs reports whether ours added a track with the ID of t,
+// which theirs added.
+func (m *merger) addedByOurs(t Track, ours []Track, oursBase []int) bool {
+	for j, u := range ours {
+		if oursBase[j] < 0 && u.ID == t.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// stepAt returns the hardware value and details of step i,
+// or a rest past the end of the track.
+func (t Track) stepAt(i int) (byte, Step) {
+	if i >= len(t.Sequence) {
+		return 0, Step{}
+	}
+	return t.Sequence[i], t.Step(i)
+}
+
+// setDetails keeps the step details of the track's hits
+// if any differ from DefaultStep.
+func (t *Track) setDetails(details []Step) {
+	t.Steps = nil
+	for i, v := range t.Sequence {
+		if v == 1 && details[i] != DefaultStep {
+			t.Steps = details
+			return
+		}
+	}
+}
+
+// resize returns the track with its steps cut or padded with rests
+// to the given number.
+func resize(t Track, steps int) Track {
+	if len(t.Sequence) == steps {
+		return t
+	}
+	u := Track{ID: t.ID, Name: t.Name, Sequence: make([]byte, steps)}
+	details := make([]Step, steps)
+	for i := range u.Sequence {
+		u.Sequence[i], details[i] = t.stepAt(i)
+	}
+	u.setDetails(details)
+	return u
+}
+
+func equalTracks(a, b Track) bool {
+	return len(diffTracks(a, b)) == 0
+}
@@ -0,0 +1,124 @@
+package drum
+
+import (
+	"reflect"
+	"testing"
+)
+
+const mergeBase = `Saved with HW Version: 0.808-alpha
+Tempo: 120
+(0) kick	|x---|x---|x---|x---|
+(1) snare	|----|x---|----|x---|
+(2) clap	|----|x-x-|----|----|
+(5) cowbell	|----|----|--x-|----|
+`
+
+func parseBackups(t *testing.T, backups ...string) []*Pattern {
+	var patterns []*Pattern
+	for _, b := range backups {
+		p, err := NewPatternFromBackup(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+func TestMerge(t *testing.T) {
+	p := parseBackups(t, mergeBase, `Saved with HW Version: 0.808-alpha
+Tempo: 128
+(0) kick	|x---|x---|x---|x-x-|
+(1) rim	|----|x---|----|x---|
+(7) shaker	|xxxx|xxxx|xxxx|xxxx|
+(2) clap	|----|x-x-|----|----|
+(5) cowbell	|----|----|--x-|----|
+`, `Saved with HW Version: 0.808-alpha
+Tempo: 120
+Swing: 62%
+(0) kick	|X---|x---|x---|x---|	0:p50
+(1) snare	|----|x---|----|x---|
+(2) clap	|----|x-x-|----|----|
+(9) tom	|--x-|----|----|----|
+`)
+	merged, conflicts := Merge(p[0], p[1], p[2])
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts but received %v", conflicts)
+	}
+	expected := `Saved with HW Version: 0.808-alpha
+Tempo: 128
+Swing: 62%
+(0) kick	|X---|x---|x---|x-x-|	0:p50
+(1) rim	|----|x---|----|x---|
+(7) shaker	|xxxx|xxxx|xxxx|xxxx|
+(2) clap	|----|x-x-|----|----|
+(9) tom	|--x-|----|----|----|
+`
+	if merged.String() != expected {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", expected, merged)
+	}
+	if again, conflicts := Merge(p[0], merged, merged); len(conflicts) != 0 || again.String() != expected {
+		t.Fatalf("Expected merging equal sides to take them but received:\n%v\n%v", again, conflicts)
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	p := parseBackups(t, mergeBase, `Saved with HW Version: 0.808-alpha
+Tempo: 128
+(0) kick	|x---|x---|x---|x-x-|
+(1) rim	|----|x---|----|x---|
+(2) clap	|----|x-x-|----|--x-|
+(4) hh	|x---|----|----|----|
+`, `Saved with HW Version: 0.909
+Tempo: 100
+(0) kick	|x---|x---|x---|x-X-|
+(1) side stick	|----|x---|----|x---|
+(4) hh-close	|x---|----|----|----|
+(5) cowbell	|----|----|--x-|---x|
+`)
+	merged, conflicts := Merge(p[0], p[1], p[2])
+	expected := []Conflict{
+		{Kind: ChangeTempo, Ours: "128", Theirs: "100"},
+		{Kind: ChangeStep, Track: 0, Step: 14, Ours: "x", Theirs: "X"},
+		{Kind: ChangeTrackRenamed, Track: 1, Ours: "rim", Theirs: "side stick"},
+		{Kind: ChangeTrackRemoved, Track: 2, Ours: "clap"},
+		{Kind: ChangeTrackAdded, Track: 4, Ours: "hh", Theirs: "hh-close"},
+		{Kind: ChangeTrackRemoved, Track: 5, Theirs: "cowbell"},
+	}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Fatalf("Expected conflicts:\n%v\nReceived:\n%v", expected, conflicts)
+	}
+	// Conflicts are resolved in favor of ours.
+	expectedText := `Saved with HW Version: 0.909
+Tempo: 128
+(0) kick	|x---|x---|x---|x-x-|
+(1) rim	|----|x---|----|x---|
+(2) clap	|----|x-x-|----|--x-|
+(4) hh	|x---|----|----|----|
+`
+	if merged.String() != expectedText {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", expectedText, merged)
+	}
+	if s := conflicts[1].String(); s != "track 0 step 14: x <> X" {
+		t.Fatalf("Expected 'track 0 step 14: x <> X' but received '%v'", s)
+	}
+}
+
+func TestMergeTimeSignature(t *testing.T) {
+	p := parseBackups(t, mergeBase, `Steps: 4 per beat, 4 beats per bar, 2 bars
+(0) kick	|x---|x---|x---|x---|x---|x---|x---|x---|
+`, mergeBase+"(3) hh\t|x-x-|x-x-|x-x-|x-x-|\n")
+	merged, conflicts := Merge(p[0], p[1], p[2])
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts but received %v", conflicts)
+	}
+	expected := `Saved with HW Version: 
+Tempo: 0
+Steps: 4 per beat, 4 beats per bar, 2 bars
+(0) kick	|x---|x---|x---|x---|x---|x---|x---|x---|
+(3) hh	|x-x-|x-x-|x-x-|x-x-|----|----|----|----|
+`
+	if merged.String() != expected {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", expected, merged)
+	}
+}
@@ -0,0 +1,372 @@
+package drum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// songChunkID marks an arrangement chunk. A song is stored as the chunks
+// of its patterns followed by a single arrangement chunk.
+//
+// The payload of an arrangement chunk is a version byte followed by its
+// sections, each made of a little-endian uint16 pattern index, uint16
+// repeat count and float32 tempo, then a byte counting the uint32 IDs
+// of muted tracks that follow and a byte counting the uint32 IDs of
+// soloed tracks that follow.
+const songChunkID = "SPLARR"
+
+// songVersion is the version of the arrangement chunk layout.
+const songVersion = 1
+
+// A Song arranges patterns into a performance.
+type Song struct {
+	Patterns []Pattern
+	Sections []Section
+}
+
+// A Section of a song plays one of the song's patterns.
+type Section struct {
+	Pattern int // index of the pattern in Song.Patterns
+	Repeats int // number of times the pattern is played, at least 1
+	// Tempo overrides the tempo of the pattern unless it is zero.
+	Tempo float32
+	// Mute holds the IDs of tracks silenced in the section.
+	Mute []uint32
+	// Solo holds the IDs of the only tracks played in the section,
+	// unless it is empty.
+	Solo []uint32
+}
+
+// Audible reports whether the track with the given ID plays in the section.
+func (s Section) Audible(id uint32) bool {
+	if contains(s.Mute, id) {
+		return false
+	}
+	return len(s.Solo) == 0 || contains(s.Solo, id)
+}
+
+func contains(ids []uint32, id uint32) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Song) validate() error {
+	for i, sec := range s.Sections {
+		switch {
+		case sec.Pattern < 0 || sec.Pattern >= len(s.Patterns):
+			return fmt.Errorf("drum: section %d plays pattern %d of %d", i, sec.Pattern, len(s.Patterns))
+		case sec.Repeats < 1 || sec.Repeats > 0xffff:
+			return fmt.Errorf("drum: section %d repeat count %d is out of range", i, sec.Repeats)
+		case sec.Tempo < 0:
+			return fmt.Errorf("drum: section %d tempo %v is out of range", i, sec.Tempo)
+		case len(sec.Mute) > 255 || len(sec.Solo) > 255:
+			return fmt.Errorf("drum: section %d mutes or solos more than 255 tracks", i)
+		}
+	}
+	if len(s.Patterns) > 0xffff {
+		return fmt.Errorf("drum: song has %d patterns", len(s.Patterns))
+	}
+	return nil
+}
+
+// sectionHeader is the encoded form of a section, less its track IDs.
+type sectionHeader struct {
+	Pattern uint16
+	Repeats uint16
+	Tempo   float32
+}
+
+// arrangement returns the payload of the arrangement chunk for the song.
+func (s Song) arrangement() []byte {
+	b := bytes.NewBuffer([]byte{songVersion})
+	for _, sec := range s.Sections {
+		binary.Write(b, binary.LittleEndian, sectionHeader{uint16(sec.Pattern), uint16(sec.Repeats), sec.Tempo})
+		for _, ids := range [][]uint32{sec.Mute, sec.Solo} {
+			b.WriteByte(byte(len(ids)))
+			binary.Write(b, binary.LittleEndian, ids)
+		}
+	}
+	return b.Bytes()
+}
+
+// EncodeSong writes to the encoder's output stream to serialize a song
+// as the chunks of its patterns followed by an arrangement chunk.
+// The Trailing bytes of the song's patterns are not written.
+func (e *Encoder) EncodeSong(s Song) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	if err := e.Flush(); err != nil {
+		return err
+	}
+	for _, p := range s.Patterns {
+		if err := e.writePattern(p); err != nil {
+			return err
+		}
+	}
+	return e.writeChunk(songChunkID, s.arrangement())
+}
+
+// DecodeSongFile decodes the song found at the provided path.
+func DecodeSongFile(path string) (*Song, error) {
+	s := new(Song)
+	f, err := os.Open(path)
+	if err != nil {
+		return s, err
+	}
+	defer f.Close()
+	return s, NewDecoder(f).DecodeSong(s)
+}
+
+// DecodeSong reads pattern chunks from the decoder's input stream
+// up to and including an arrangement chunk to initialize a song.
+// It returns a *SyntaxError if the input ends before the arrangement chunk.
+func (d *Decoder) DecodeSong(s *Song) error {
+	s.Patterns, s.Sections = nil, nil
+	for {
+		if id, _ := d.buf.Peek(len(songChunkID)); string(id) == songChunkID {
+			break
+		}
+		offset := d.r.n
+		p := NewPattern()
+		err := d.Decode(p)
+		if err == io.EOF {
+			return &SyntaxError{Offset: offset, Field: FieldArrangement, Track: -1, Chunk: d.chunk, Err: ErrTruncated}
+		}
+		if err != nil {
+			return err
+		}
+		s.Patterns = append(s.Patterns, *p)
+	}
+	if _, err := d.readChunk(); err != nil {
+		return err
+	}
+	return d.readArrangement(s)
+}
+
+// readArrangement decodes the payload of an arrangement chunk into s.
+func (d *Decoder) readArrangement(s *Song) error {
+	var version byte
+	if err := d.read(FieldArrangement, -1, &version); err != nil {
+		return err
+	}
+	if version != songVersion {
+		return d.syntaxError(d.r.n-1, FieldArrangement, -1, ErrBadArrangement)
+	}
+	for d.payload.N > 0 {
+		offset := d.r.n
+		var h sectionHeader
+		if err := d.read(FieldArrangement, -1, &h); err != nil {
+			return err
+		}
+		sec := Section{Pattern: int(h.Pattern), Repeats: int(h.Repeats), Tempo: h.Tempo}
+		for _, ids := range []*[]uint32{&sec.Mute, &sec.Solo} {
+			var n byte
+			if err := d.read(FieldArrangement, -1, &n); err != nil {
+				return err
+			}
+			if n == 0 {
+				continue
+			}
+			*ids = make([]uint32, n)
+			if err := d.read(FieldArrangement, -1, *ids); err != nil {
+				return err
+			}
+		}
+		s.Sections = append(s.Sections, sec)
+		if (Song{Patterns: s.Patterns, Sections: []Section{sec}}).validate() != nil {
+			return d.syntaxError(offset, FieldArrangement, -1, ErrBadArrangement)
+		}
+	}
+	return nil
+}
+
+const (
+	songPatternPrefix = "Pattern "
+	songArrangement   = "Arrangement"
+)
+
+// String returns the song in the text format read by NewSongFromBackup:
+// every pattern in its backup format, headed by its number counted from 1,
+// followed by the arrangement with a section on each line.
+func (s Song) String() string {
+	var b strings.Builder
+	for i, p := range s.Patterns {
+		fmt.Fprintf(&b, "%s%d\n%v\n", songPatternPrefix, i+1, p)
+	}
+	b.WriteString(songArrangement + "\n")
+	for _, sec := range s.Sections {
+		b.WriteString(sec.String() + "\n")
+	}
+	return b.String()
+}
+
+// String returns the section as the number of its pattern counted from 1,
+// followed by the settings that differ from playing the pattern once
+// as it is, such as "2 x4 tempo 128 mute 1,3".
+func (s Section) String() string {
+	str := strconv.Itoa(s.Pattern + 1)
+	if s.Repeats != 1 {
+		str += fmt.Sprintf(" x%d", s.Repeats)
+	}
+	if s.Tempo != 0 {
+		str += fmt.Sprintf(" tempo %v", s.Tempo)
+	}
+	for _, list := range []struct {
+		keyword string
+		ids     []uint32
+	}{{"mute", s.Mute}, {"solo", s.Solo}} {
+		if len(list.ids) == 0 {
+			continue
+		}
+		ids := make([]string, len(list.ids))
+		for i, id := range list.ids {
+			ids[i] = strconv.FormatUint(uint64(id), 10)
+		}
+		str += " " + list.keyword + " " + strings.Join(ids, ",")
+	}
+	return str
+}
+
+// NewSongFromBackup creates a song by parsing the text format
+// returned by Song.String, following the grammar described
+// with NewPatternFromBackup.
+// It returns a *ParseError if the text does not follow the format.
+func NewSongFromBackup(str string) (*Song, error) {
+	s := new(Song)
+	scanner := bufio.NewScanner(strings.NewReader(str))
+	var pattern []string
+	arranging := false
+	lineNum, patternLine := 0, 0
+	endPattern := func() error {
+		if pattern == nil {
+			return nil
+		}
+		p, err := NewPatternFromBackup(strings.Join(pattern, "\n"))
+		var perr *ParseError
+		if errors.As(err, &perr) {
+			// Count lines from the start of the song.
+			perr.Line += patternLine
+		}
+		if err != nil {
+			return err
+		}
+		s.Patterns = append(s.Patterns, *p)
+		pattern = nil
+		return nil
+	}
+	for ; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		sc := &lineScanner{text: line, line: lineNum + 1}
+		switch {
+		case !arranging && strings.HasPrefix(line, songPatternPrefix):
+			if err := endPattern(); err != nil {
+				return s, err
+			}
+			if err := sc.heading(len(s.Patterns) + 1); err != nil {
+				return s, err
+			}
+			pattern, patternLine = []string{}, lineNum+1
+		case !arranging && line == songArrangement:
+			if err := endPattern(); err != nil {
+				return s, err
+			}
+			arranging = true
+		case strings.TrimSpace(line) == "":
+			if err := endPattern(); err != nil {
+				return s, err
+			}
+		case arranging:
+			sec, err := sc.section()
+			if err != nil {
+				return s, err
+			}
+			s.Sections = append(s.Sections, sec)
+		case pattern != nil:
+			pattern = append(pattern, line)
+		default:
+			return s, sc.errorf("expected a pattern or arrangement heading")
+		}
+	}
+	if err := endPattern(); err != nil {
+		return s, err
+	}
+	if !arranging {
+		sc := &lineScanner{line: lineNum + 1}
+		return s, sc.errorf("expected an arrangement heading but found end of song")
+	}
+	return s, s.validate()
+}
+
+// heading consumes the heading of pattern n.
+func (s *lineScanner) heading(n int) error {
+	s.literal(songPatternPrefix)
+	start := s.pos
+	heading, err := s.int("a pattern number")
+	if err != nil {
+		return err
+	}
+	if heading != n {
+		return s.errorAt(start, "expected pattern %d but found pattern %d", n, heading)
+	}
+	return s.end()
+}
+
+// section consumes a section of an arrangement.
+func (s *lineScanner) section() (Section, error) {
+	sec := Section{Repeats: 1}
+	n, err := s.int("a pattern number")
+	if err != nil {
+		return sec, err
+	}
+	sec.Pattern = n - 1
+	for s.skipBlanks(); !s.done(); s.skipBlanks() {
+		switch {
+		case s.literal("x"):
+			if sec.Repeats, err = s.int("a repeat count"); err != nil {
+				return sec, err
+			}
+		case s.literal("tempo "):
+			if sec.Tempo, err = s.tempo(); err != nil {
+				return sec, err
+			}
+		case s.literal("mute "):
+			if sec.Mute, err = s.ids(); err != nil {
+				return sec, err
+			}
+		case s.literal("solo "):
+			if sec.Solo, err = s.ids(); err != nil {
+				return sec, err
+			}
+		default:
+			return sec, s.errorf("expected a section setting but found %s", s.found())
+		}
+	}
+	return sec, nil
+}
+
+// ids consumes a list of track IDs separated by commas.
+func (s *lineScanner) ids() ([]uint32, error) {
+	var ids []uint32
+	for {
+		id, err := s.uint("a track ID", 32)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint32(id))
+		if !s.literal(",") {
+			return ids, nil
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package drum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func testSong(t *testing.T) Song {
+	var s Song
+	for _, name := range []string{"pattern_1.splice", "pattern_2.splice", "pattern_3.splice"} {
+		p, err := DecodeFile(path.Join("patterns", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Patterns = append(s.Patterns, *p)
+	}
+	s.Patterns[1].Swing = Swing{Amount: 58}
+	s.Sections = []Section{
+		{Pattern: 0, Repeats: 4},
+		{Pattern: 1, Repeats: 2, Tempo: 128.5, Mute: []uint32{1, 3}},
+		{Pattern: 2, Repeats: 1, Solo: []uint32{0}},
+		{Pattern: 0, Repeats: 1, Mute: []uint32{2}, Solo: []uint32{0, 1}},
+	}
+	return s
+}
+
+func TestEncodeSong(t *testing.T) {
+	s := testSong(t)
+	b := new(bytes.Buffer)
+	if err := NewEncoder(b).EncodeSong(s); err != nil {
+		t.Fatal(err)
+	}
+	encoded := append([]byte{}, b.Bytes()...)
+	decoded := new(Song)
+	if err := NewDecoder(b).DecodeSong(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Sections, s.Sections) {
+		t.Fatalf("Expected sections %+v but received %+v", s.Sections, decoded.Sections)
+	}
+	if fmt.Sprint(decoded) != fmt.Sprint(s) {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", s, decoded)
+	}
+	reencoded := new(bytes.Buffer)
+	if err := NewEncoder(reencoded).EncodeSong(*decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, reencoded.Bytes()) {
+		t.Fatal("Expected the song to be re-encoded identically")
+	}
+
+	// The patterns of a song can be decoded one at a time,
+	// up to the arrangement chunk.
+	d := NewDecoder(bytes.NewReader(encoded))
+	for range s.Patterns {
+		if err := d.Decode(NewPattern()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Decode(NewPattern()); !errors.Is(err, ErrBadMagic) {
+		t.Fatalf("Expected ErrBadMagic decoding the arrangement as a pattern but received %v", err)
+	}
+}
+
+func TestDecodeSongErrors(t *testing.T) {
+	s := testSong(t)
+	b := new(bytes.Buffer)
+	if err := NewEncoder(b).EncodeSong(s); err != nil {
+		t.Fatal(err)
+	}
+	encoded := b.Bytes()
+	arrangement := bytes.LastIndex(encoded, []byte(songChunkID))
+
+	err := NewDecoder(bytes.NewReader(encoded[:arrangement])).DecodeSong(new(Song))
+	var serr *SyntaxError
+	if !errors.As(err, &serr) || serr.Field != FieldArrangement || serr.Err != ErrTruncated {
+		t.Fatalf("Expected a truncated arrangement but received %v", err)
+	}
+
+	// Point the first section at a fourth pattern.
+	bad := append([]byte{}, encoded...)
+	bad[arrangement+chunkHeaderSize+1] = 3
+	err = NewDecoder(bytes.NewReader(bad)).DecodeSong(new(Song))
+	if !errors.As(err, &serr) || serr.Err != ErrBadArrangement || serr.Offset != int64(arrangement+chunkHeaderSize+1) {
+		t.Fatalf("Expected a bad arrangement at offset %v but received %v", arrangement+chunkHeaderSize+1, err)
+	}
+}
+
+func TestEncodeSongInvalid(t *testing.T) {
+	for _, sec := range []Section{
+		{Pattern: 3, Repeats: 1},
+		{Pattern: 0, Repeats: 0},
+		{Pattern: 0, Repeats: 1, Tempo: -1},
+	} {
+		s := testSong(t)
+		s.Sections = append(s.Sections, sec)
+		if err := NewEncoder(new(bytes.Buffer)).EncodeSong(s); err == nil {
+			t.Fatalf("Expected an error encoding section %+v", sec)
+		}
+	}
+}
+
+func TestNewSongFromBackup(t *testing.T) {
+	s := testSong(t)
+	parsed, err := NewSongFromBackup(s.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != s.String() {
+		t.Fatalf("Expected:\n%v\nReceived:\n%v", s, parsed)
+	}
+	if !reflect.DeepEqual(parsed.Sections, s.Sections) {
+		t.Fatalf("Expected sections %+v but received %+v", s.Sections, parsed.Sections)
+	}
+	if line := s.Sections[1].String(); line != "2 x2 tempo 128.5 mute 1,3" {
+		t.Fatalf("Expected section '2 x2 tempo 128.5 mute 1,3' but received '%v'", line)
+	}
+
+	for _, backup := range []string{
+		"Pattern 2\nSaved with HW Version: 0.808-alpha\nTempo: 120\n\nArrangement\n1\n",
+		"Pattern 1\nSaved with HW Version: 0.808-alpha\nTempo: 120\n\nArrangement\n2\n",
+		"Pattern 1\nSaved with HW Version: 0.808-alpha\nTempo: 120\n\nArrangement\n1 x\n",
+		"Pattern 1\nSaved with HW Version: 0.808-alpha\nTempo: 120\n\nArrangement\n1 tempo\n",
+		"Pattern 1\nSaved with HW Version: 0.808-alpha\nTempo: 120\n\nArrangement\n1 loud 3\n",
+		"Pattern 1\nSaved with HW Version: 0.808-alpha\nTempo: 120\n",
+		"Tempo: 120\n\nArrangement\n",
+	} {
+		if _, err := NewSongFromBackup(backup); err == nil {
+			t.Fatalf("Expected an error parsing:\n%v", backup)
+		}
+	}
+}
+
+func TestSectionAudible(t *testing.T) {
+	sec := Section{Mute: []uint32{2}, Solo: []uint32{0, 2}}
+	for id, expected := range []bool{true, false, false} {
+		if sec.Audible(uint32(id)) != expected {
+			t.Fatalf("Expected track %v to be audible: %v", id, expected)
+		}
+	}
+	if !(Section{}).Audible(7) {
+		t.Fatal("Expected tracks to be audible in a section without mutes or solos")
+	}
+}
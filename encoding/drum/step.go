@@ -0,0 +1,140 @@
+package drum
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Step holds the details of a hit in a track's sequence.
+type Step struct {
+	Velocity    uint8 // loudness of the hit from 1 to 127
+	Probability uint8 // chance of the hit sounding in percent, from 1 to 100
+	Offset      int8  // micro-timing offset of the hit in 128ths of a step
+	Ratchets    uint8 // number of hits spread evenly across the step, at least 1
+}
+
+// Velocities of the hits the text backup format has a character for.
+const (
+	DefaultVelocity = 100
+	AccentVelocity  = 127
+	GhostVelocity   = 40
+)
+
+// DefaultStep is a hit of a track without step details.
+var DefaultStep = Step{Velocity: DefaultVelocity, Probability: 100, Ratchets: 1}
+
+func (s Step) validate() error {
+	if s.Velocity < 1 || s.Velocity > 127 {
+		return fmt.Errorf("drum: step velocity %d is out of range", s.Velocity)
+	}
+	if s.Probability < 1 || s.Probability > 100 {
+		return fmt.Errorf("drum: step probability %d is out of range", s.Probability)
+	}
+	if s.Ratchets < 1 {
+		return fmt.Errorf("drum: step has no ratchets")
+	}
+	return nil
+}
+
+// Step returns the details of step i of the track.
+// It returns the zero Step if the step is not a hit
+// and DefaultStep for hits without step details, such as those of
+// a track without step details or past the end of a short Steps.
+func (t Track) Step(i int) Step {
+	if t.Sequence[i] != 1 {
+		return Step{}
+	}
+	if i >= len(t.Steps) {
+		return DefaultStep
+	}
+	return t.Steps[i]
+}
+
+func (t Track) validateSteps() error {
+	if t.Steps == nil {
+		return nil
+	}
+	if len(t.Steps) != len(t.Sequence) {
+		return fmt.Errorf("drum: track %q has %d step details for %d steps",
+			t.Name, len(t.Steps), len(t.Sequence))
+	}
+	for i := range t.Sequence {
+		if t.Sequence[i] != 1 {
+			continue
+		}
+		if err := t.Steps[i].validate(); err != nil {
+			return fmt.Errorf("%v at step %d of track %q", err, i, t.Name)
+		}
+	}
+	return nil
+}
+
+// setSteps initializes the track's sequence from steps,
+// where the zero Step is not a hit.
+// Step details are only kept if a hit differs from DefaultStep.
+func (t *Track) setSteps(steps []Step) {
+	t.Sequence = make([]byte, len(steps))
+	t.Steps = nil
+	for i, s := range steps {
+		if s == (Step{}) {
+			continue
+		}
+		t.Sequence[i] = 1
+		if s != DefaultStep {
+			t.Steps = steps
+		}
+	}
+}
+
+const (
+	accentBeat rune = 'X'
+	ghostBeat  rune = 'o'
+)
+
+// beatRune returns the character a hit is rendered with
+// and whether the hit has further details to be annotated.
+func beatRune(s Step) (r rune, annotate bool) {
+	switch s.Velocity {
+	case AccentVelocity:
+		r = accentBeat
+	case GhostVelocity:
+		r = ghostBeat
+	default:
+		r = onBeat
+	}
+	annotate = s != runeStep(r)
+	return r, annotate
+}
+
+// runeStep returns the step a beat character stands for.
+func runeStep(r rune) Step {
+	s := DefaultStep
+	switch r {
+	case accentBeat:
+		s.Velocity = AccentVelocity
+	case ghostBeat:
+		s.Velocity = GhostVelocity
+	}
+	return s
+}
+
+// annotation renders the details of step i that the character
+// for its velocity does not imply, such as "5:v90,p50,t-8,r2".
+func annotation(i int, s Step) string {
+	r, _ := beatRune(s)
+	implied := runeStep(r)
+	var fields []string
+	if s.Velocity != implied.Velocity {
+		fields = append(fields, fmt.Sprintf("v%d", s.Velocity))
+	}
+	if s.Probability != implied.Probability {
+		fields = append(fields, fmt.Sprintf("p%d", s.Probability))
+	}
+	if s.Offset != implied.Offset {
+		fields = append(fields, fmt.Sprintf("t%+d", s.Offset))
+	}
+	if s.Ratchets != implied.Ratchets {
+		fields = append(fields, fmt.Sprintf("r%d", s.Ratchets))
+	}
+	return fmt.Sprintf("%d:%s", i, strings.Join(fields, ","))
+}
@@ -0,0 +1,68 @@
+package drum
+
+import (
+	"fmt"
+	"math"
+)
+
+// Swing delays every second note of a pattern's eighth or sixteenth notes,
+// lengthening the first note of each pair at the expense of the second.
+// The zero value plays steps evenly spaced.
+type Swing struct {
+	// Amount is the percentage of each pair of notes taken by the first,
+	// from 50 for straight timing to 75 for a hard shuffle.
+	Amount int
+	// Resolution is the note value that is swung, 8 or 16.
+	// Zero stands for 16.
+	Resolution int
+}
+
+// Limits of Swing.Amount.
+const (
+	MinSwing = 50
+	MaxSwing = 75
+)
+
+func (s Swing) validate() error {
+	if s.Amount != 0 && (s.Amount < MinSwing || s.Amount > MaxSwing) {
+		return fmt.Errorf("drum: swing amount %d%% is out of range", s.Amount)
+	}
+	if s.Resolution != 0 && s.Resolution != 8 && s.Resolution != 16 {
+		return fmt.Errorf("drum: swing resolution %d is neither 8 nor 16", s.Resolution)
+	}
+	return nil
+}
+
+// Apply returns the time in quarter notes from the start of a pattern
+// at which a note placed at the given time in quarter notes is played.
+// Times within each pair of notes are stretched linearly, so notes
+// offset from the grid keep their place relative to the swung notes.
+func (s Swing) Apply(quarters float64) float64 {
+	if s.Amount == 0 || s.Amount == 50 {
+		return quarters
+	}
+	// A pair of sixteenth notes lasts an eighth note.
+	pair := 0.5
+	if s.Resolution == 8 {
+		pair = 1
+	}
+	n := math.Floor(quarters / pair)
+	x := quarters/pair - n
+	first := float64(s.Amount) / 100
+	if x < 0.5 {
+		x *= 2 * first
+	} else {
+		x = first + (x-0.5)*2*(1-first)
+	}
+	return (n + x) * pair
+}
+
+const swingPrefix = "Swing: "
+
+func (s Swing) String() string {
+	str := fmt.Sprintf("%d%%", s.Amount)
+	if s.Resolution != 0 {
+		str += fmt.Sprintf(" %dth", s.Resolution)
+	}
+	return str
+}
@@ -0,0 +1,284 @@
+package midi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"splice/encoding/drum"
+)
+
+// Reasons a note is reported as misplaced by a Decoder.
+var (
+	// ErrOffGrid means a note was moved to the nearest step.
+	ErrOffGrid = errors.New("midi: note off the step grid")
+	// ErrOutOfRange means a note fell after the end of the pattern and was dropped.
+	ErrOutOfRange = errors.New("midi: note outside the pattern")
+	// ErrUnmapped means the drum map has no track for a note and it was dropped.
+	ErrUnmapped = errors.New("midi: note without a track")
+)
+
+// A Note is a note on event of a Standard MIDI File.
+type Note struct {
+	Track    int // index of the track chunk holding the note
+	Tick     int // time of the note in ticks from the start of the file
+	Key      uint8
+	Velocity uint8
+}
+
+// A Misplaced note could not be placed exactly onto the pattern grid.
+type Misplaced struct {
+	Note
+	Step   int   // the step the note was moved to or would have fallen on
+	Reason error // ErrOffGrid, ErrOutOfRange or ErrUnmapped
+}
+
+func (m Misplaced) String() string {
+	return fmt.Sprintf("%v: key %d in track %d at tick %d (step %d)",
+		m.Reason, m.Key, m.Track, m.Tick, m.Step)
+}
+
+// A Decoder reads drum patterns from Standard MIDI Files.
+//
+// Note on events on Channel are quantized to the nearest step and
+// assigned to tracks by the drum map. The velocity of every note is kept
+// in the step details of its track. Other events are ignored apart from
+// the first tempo event, which sets the tempo of the pattern.
+type Decoder struct {
+	r *bufio.Reader
+	// StepsPerQuarter is the number of pattern steps per quarter note.
+	StepsPerQuarter int
+	// TimeSignature sets the number of steps of the pattern.
+	TimeSignature drum.TimeSignature
+	// Tolerance is the fraction of a step a note may be off the grid
+	// before it is reported as misplaced.
+	Tolerance float64
+	// DrumMap assigns tracks to notes.
+	DrumMap DrumMap
+}
+
+// NewDecoder creates a new MIDI decoder reading from r with sixteenth note
+// steps, the default time signature and General MIDI notes.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:               bufio.NewReader(r),
+		StepsPerQuarter: 4,
+		DrumMap:         GeneralMIDI,
+	}
+}
+
+// DefaultTempo is the tempo of files without a tempo event.
+const DefaultTempo = 120
+
+// Decode reads a Standard MIDI File from the decoder's input stream
+// to initialize the tempo, time signature and tracks of a drum pattern.
+// The pattern's hardware version is left untouched.
+// It returns the notes that were not placed exactly onto the pattern grid.
+func (d *Decoder) Decode(p *drum.Pattern) ([]Misplaced, error) {
+	if d.StepsPerQuarter < 1 {
+		return nil, fmt.Errorf("midi: steps per quarter note %d out of range", d.StepsPerQuarter)
+	}
+	ppq, notes, usec, err := d.readFile()
+	if err != nil {
+		return nil, err
+	}
+	p.Tempo = DefaultTempo
+	if usec > 0 {
+		// Tempo events hold whole microseconds, round off the error.
+		p.Tempo = float32(math.Round(60e6/float64(usec)*100) / 100)
+	}
+	p.TimeSignature = d.TimeSignature
+	steps := p.Steps()
+	stepTicks := float64(ppq) / float64(d.StepsPerQuarter)
+
+	var misplaced []Misplaced
+	hits := make(map[uint8][]drum.Step)
+	for _, n := range notes {
+		// Every note is reported once, for the reason it is dropped if it is.
+		step := int(math.Round(float64(n.Tick) / stepTicks))
+		if step >= steps {
+			misplaced = append(misplaced, Misplaced{n, step, ErrOutOfRange})
+			continue
+		}
+		if _, _, ok := d.DrumMap.Track(n.Key); !ok {
+			misplaced = append(misplaced, Misplaced{n, step, ErrUnmapped})
+			continue
+		}
+		if math.Abs(float64(n.Tick)/stepTicks-float64(step)) > d.Tolerance {
+			misplaced = append(misplaced, Misplaced{n, step, ErrOffGrid})
+		}
+		if hits[n.Key] == nil {
+			hits[n.Key] = make([]drum.Step, steps)
+		}
+		s := &hits[n.Key][step]
+		if n.Velocity > s.Velocity {
+			*s = drum.DefaultStep
+			s.Velocity = n.Velocity
+		}
+	}
+
+	var keys []int
+	for k := range hits {
+		keys = append(keys, int(k))
+	}
+	sort.Ints(keys)
+	p.Tracks = make(drum.Tracks, 0, len(keys))
+	for _, k := range keys {
+		t := drum.Track{Sequence: make([]byte, steps)}
+		t.ID, t.Name, _ = d.DrumMap.Track(uint8(k))
+		for i, s := range hits[uint8(k)] {
+			if s.Velocity == 0 {
+				continue
+			}
+			t.Sequence[i] = 1
+			if s != drum.DefaultStep {
+				t.Steps = hits[uint8(k)]
+			}
+		}
+		p.Tracks = append(p.Tracks, t)
+	}
+	return misplaced, nil
+}
+
+// readFile reads the header and tracks of a Standard MIDI File and returns
+// its ticks per quarter note, its drum note on events in chronological
+// order and the first tempo in microseconds per quarter note, if any.
+func (d *Decoder) readFile() (ppq int, notes []Note, usec int, err error) {
+	id, data, err := d.readChunk()
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if id != "MThd" || len(data) < 6 {
+		return 0, nil, 0, fmt.Errorf("midi: not a Standard MIDI File")
+	}
+	format := binary.BigEndian.Uint16(data[0:])
+	tracks := int(binary.BigEndian.Uint16(data[2:]))
+	division := binary.BigEndian.Uint16(data[4:])
+	if format > 2 {
+		return 0, nil, 0, fmt.Errorf("midi: unsupported format %d", format)
+	}
+	if division&0x8000 != 0 || division == 0 {
+		return 0, nil, 0, fmt.Errorf("midi: unsupported time division %#x", division)
+	}
+	usecTick := -1
+	for track := 0; track < tracks; {
+		id, data, err := d.readChunk()
+		if err == io.EOF {
+			return 0, nil, 0, fmt.Errorf("midi: expected %d tracks but found %d", tracks, track)
+		}
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		if id != "MTrk" {
+			continue
+		}
+		err = readEvents(data, func(tick int, event []byte) {
+			switch {
+			case event[0] == noteOn|Channel && event[2] > 0:
+				notes = append(notes, Note{track, tick, event[1], event[2]})
+			case event[0] == meta && event[1] == metaTempo && len(event) == 6:
+				if usecTick < 0 || tick < usecTick {
+					usec = int(event[3])<<16 | int(event[4])<<8 | int(event[5])
+					usecTick = tick
+				}
+			}
+		})
+		if err != nil {
+			return 0, nil, 0, fmt.Errorf("midi: track %d: %v", track, err)
+		}
+		track++
+	}
+	sort.SliceStable(notes, func(i, j int) bool { return notes[i].Tick < notes[j].Tick })
+	return int(division), notes, usec, nil
+}
+
+func (d *Decoder) readChunk() (id string, data []byte, err error) {
+	var h struct {
+		ID     [4]byte
+		Length uint32
+	}
+	if err := binary.Read(d.r, binary.BigEndian, &h); err != nil {
+		return "", nil, err
+	}
+	// A chunk may claim more bytes than the file holds.
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d.r, int64(h.Length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", nil, err
+	}
+	return string(h.ID[:]), buf.Bytes(), nil
+}
+
+// readEvents calls f with the absolute tick and the bytes of every event
+// of a track chunk. Channel events are passed with their status byte,
+// meta events with their 0xff prefix and type followed by the length.
+func readEvents(data []byte, f func(tick int, event []byte)) error {
+	tick := 0
+	var status byte
+	for len(data) > 0 {
+		delta, n := readVarint(data)
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		tick += delta
+		data = data[n:]
+		if len(data) == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		var event []byte
+		switch b := data[0]; {
+		case b == meta || b == 0xf0 || b == 0xf7:
+			start := 1
+			if b == meta {
+				start = 2
+			}
+			if len(data) <= start {
+				return io.ErrUnexpectedEOF
+			}
+			length, n := readVarint(data[start:])
+			end := start + n + length
+			if n == 0 || end > len(data) {
+				return io.ErrUnexpectedEOF
+			}
+			event, data = data[:end], data[end:]
+			status = 0
+		default:
+			if b&0x80 != 0 {
+				status, data = b, data[1:]
+			}
+			if status == 0 {
+				return fmt.Errorf("data byte %#x without status", b)
+			}
+			length := 2
+			if kind := status & 0xf0; kind == 0xc0 || kind == 0xd0 {
+				length = 1
+			}
+			if len(data) < length {
+				return io.ErrUnexpectedEOF
+			}
+			event = append([]byte{status}, data[:length]...)
+			data = data[length:]
+		}
+		f(tick, event)
+	}
+	return nil
+}
+
+// readVarint returns the MIDI variable-length quantity at the start of b
+// and its length in bytes, which is zero if b holds no valid quantity.
+func readVarint(b []byte) (n, length int) {
+	for i := 0; i < len(b) && i < 4; i++ {
+		n = n<<7 | int(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return n, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,130 @@
+package midi
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"testing"
+
+	"splice/encoding/drum"
+)
+
+func TestDecodeRoundTrip(t *testing.T) {
+	for _, name := range []string{"pattern_1.splice", "pattern_3.splice", "pattern_4.splice"} {
+		p, err := drum.DecodeFile(path.Join("..", "drum", "patterns", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Map every track to a distinct note by ID and back by name.
+		// Tracks without hits leave no trace in a MIDI file.
+		m := DrumMap{IDs: make(map[uint32]uint8), Names: make(map[string]uint8)}
+		var played drum.Tracks
+		for i, track := range p.Tracks {
+			m.IDs[track.ID] = uint8(60 + i)
+			m.Names[track.Name] = uint8(60 + i)
+			if bytes.Contains(track.Sequence, []byte{1}) {
+				played = append(played, track)
+			}
+		}
+		p.Tracks = played
+		for _, format := range []int{0, 1} {
+			b := new(bytes.Buffer)
+			e := NewEncoder(b)
+			e.Format = format
+			e.DrumMap = m
+			if err := e.Encode(*p); err != nil {
+				t.Fatal(err)
+			}
+			decoded := drum.NewPattern()
+			decoded.HardwareVersion = p.HardwareVersion
+			d := NewDecoder(b)
+			d.DrumMap = m
+			misplaced, err := d.Decode(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(misplaced) != 0 {
+				t.Fatalf("Expected no misplaced notes but received %v", misplaced)
+			}
+			if fmt.Sprint(decoded) != fmt.Sprint(p) {
+				t.Fatalf("%v wasn't decoded as expected from format %v.\nGot:\n%v\nExpected:\n%v",
+					name, format, decoded, p)
+			}
+		}
+	}
+}
+
+func TestDecodeMisplaced(t *testing.T) {
+	seq := make([]byte, 16)
+	steps := make([]drum.Step, 16)
+	seq[2], steps[2] = 1, drum.Step{Velocity: 80, Probability: 100, Ratchets: 1, Offset: 20}
+	seq[15], steps[15] = 1, drum.Step{Velocity: 100, Probability: 100, Ratchets: 1, Offset: 64}
+	p := drum.Pattern{Tempo: 98.5, Tracks: drum.Tracks{
+		{ID: 1, Name: "snare", Sequence: seq, Steps: steps},
+		// An unmapped note off the grid is reported once, as unmapped.
+		{ID: 2, Name: "theremin", Sequence: append([]byte{1}, make([]byte, 15)...),
+			Steps: append([]drum.Step{{Velocity: 100, Probability: 100, Ratchets: 1, Offset: 20}}, make([]drum.Step, 15)...)},
+	}}
+	b := new(bytes.Buffer)
+	e := NewEncoder(b)
+	e.DrumMap.IDs = map[uint32]uint8{2: 81}
+	if err := e.Encode(p); err != nil {
+		t.Fatal(err)
+	}
+	decoded := drum.NewPattern()
+	d := NewDecoder(bytes.NewReader(b.Bytes()))
+	misplaced, err := d.Decode(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := make(map[error]int)
+	for _, m := range misplaced {
+		reasons[m.Reason]++
+	}
+	expected := map[error]int{ErrOffGrid: 1, ErrOutOfRange: 1, ErrUnmapped: 1}
+	if fmt.Sprint(reasons) != fmt.Sprint(expected) {
+		t.Fatalf("Expected misplaced notes %v but received %v", expected, misplaced)
+	}
+	if decoded.Tempo != 98.5 {
+		t.Fatalf("Expected tempo 98.5 but received %v", decoded.Tempo)
+	}
+	if len(decoded.Tracks) != 1 || decoded.Tracks[0].Name != "snare" {
+		t.Fatalf("Expected a single snare track but received %v", decoded.Tracks)
+	}
+	if s := decoded.Tracks[0].Step(2); s.Velocity != 80 {
+		t.Fatalf("Expected step 2 to keep velocity 80 but received %+v", s)
+	}
+
+	d = NewDecoder(bytes.NewReader(b.Bytes()))
+	d.Tolerance = 0.25
+	misplaced, err = d.Decode(drum.NewPattern())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(misplaced) != 2 {
+		t.Fatalf("Expected notes within tolerance not to be reported but received %v", misplaced)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	b := new(bytes.Buffer)
+	p, err := drum.DecodeFile(path.Join("..", "drum", "patterns", "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewEncoder(b).Encode(*p); err != nil {
+		t.Fatal(err)
+	}
+	valid := b.Bytes()
+	for _, input := range [][]byte{
+		nil,
+		[]byte("RIFF\x00\x00\x00\x06\x00\x01\x00\x01\x00\x60"),
+		[]byte("MThd\xff\xff\xff\xff\x00\x01\x00\x01\x00\x60"),
+		valid[:len(valid)-20],
+		valid[:len(valid)-60],
+	} {
+		if _, err := NewDecoder(bytes.NewReader(input)).Decode(drum.NewPattern()); err == nil {
+			t.Fatalf("Expected an error decoding % x", input)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package midi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"splice/encoding/drum"
+)
+
+// An Encoder writes drum patterns as Standard MIDI Files.
+//
+// Hits are written as notes on Channel with the velocity, micro-timing
+// offset and ratchets of their step. Step probabilities are ignored.
+type Encoder struct {
+	w io.Writer
+	// Format is 0 for a file with a single track,
+	// or 1 for a tempo track followed by a track per drum track.
+	Format int
+	// PPQ is the number of ticks per quarter note.
+	PPQ int
+	// StepsPerQuarter is the number of pattern steps per quarter note.
+	StepsPerQuarter int
+	// NoteLength is the length of every note in ticks.
+	// Zero means half a step.
+	NoteLength int
+	// Loops is the number of times the pattern is played.
+	Loops int
+	// DrumMap assigns notes to the pattern's tracks.
+	DrumMap DrumMap
+}
+
+// NewEncoder creates a new MIDI encoder writing to w which writes
+// format 1 files of 96 ticks per quarter note, with sixteenth note steps,
+// a single loop and General MIDI notes.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:               w,
+		Format:          1,
+		PPQ:             96,
+		StepsPerQuarter: 4,
+		Loops:           1,
+		DrumMap:         GeneralMIDI,
+	}
+}
+
+// An event is a MIDI event at an absolute time in ticks.
+type event struct {
+	tick int
+	data []byte
+}
+
+// order sorts meta events first and note offs before note ons
+// among events sharing a tick.
+func (e event) order() int {
+	switch e.data[0] & 0xf0 {
+	case noteOff:
+		return 1
+	case noteOn:
+		return 2
+	}
+	return 0
+}
+
+const (
+	noteOff = 0x80
+	noteOn  = 0x90
+	meta    = 0xff
+
+	metaTrackName     = 0x03
+	metaEndOfTrack    = 0x2f
+	metaTempo         = 0x51
+	metaTimeSignature = 0x58
+)
+
+// Encode writes pattern p to the encoder's output stream as a Standard MIDI File.
+func (e *Encoder) Encode(p drum.Pattern) error {
+	if err := e.validate(p); err != nil {
+		return err
+	}
+	stepTicks := float64(e.PPQ) / float64(e.StepsPerQuarter)
+	end := int(math.Round(float64(e.Loops*p.Steps()) * stepTicks))
+	conductor := []event{{0, tempoEvent(p.Tempo)}}
+	if ts := e.timeSignatureEvent(p.TimeSignature); ts != nil {
+		conductor = append(conductor, event{0, ts})
+	}
+	tracks := [][]event{conductor}
+	for _, t := range p.Tracks {
+		note, ok := e.DrumMap.Note(t)
+		if !ok {
+			return fmt.Errorf("midi: no note for track (%d) %s", t.ID, t.Name)
+		}
+		events := []event{{0, metaEvent(metaTrackName, []byte(t.Name))}}
+		events = append(events, e.notes(t, note, p.Steps(), stepTicks, p.Swing)...)
+		tracks = append(tracks, events)
+	}
+	if e.Format == 0 {
+		var merged []event
+		for _, events := range tracks {
+			for _, ev := range events {
+				if ev.data[0] != meta || ev.data[1] != metaTrackName {
+					merged = append(merged, ev)
+				}
+			}
+		}
+		tracks = [][]event{merged}
+	}
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.BigEndian, []uint16{uint16(e.Format), uint16(len(tracks)), uint16(e.PPQ)})
+	if err := writeChunk(e.w, "MThd", b.Bytes()); err != nil {
+		return err
+	}
+	for _, events := range tracks {
+		if err := writeChunk(e.w, "MTrk", encodeTrack(events, end)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) validate(p drum.Pattern) error {
+	switch {
+	case e.Format != 0 && e.Format != 1:
+		return fmt.Errorf("midi: unsupported format %d", e.Format)
+	case e.PPQ < 1 || e.PPQ > 0x7fff:
+		return fmt.Errorf("midi: ticks per quarter note %d out of range", e.PPQ)
+	case e.StepsPerQuarter < 1:
+		return fmt.Errorf("midi: steps per quarter note %d out of range", e.StepsPerQuarter)
+	case e.NoteLength < 0:
+		return fmt.Errorf("midi: negative note length %d", e.NoteLength)
+	case e.Loops < 1:
+		return fmt.Errorf("midi: loop count %d out of range", e.Loops)
+	case !(p.Tempo > 0) || 60e6/p.Tempo > 0xffffff:
+		return fmt.Errorf("midi: tempo %v out of range", p.Tempo)
+	}
+	return nil
+}
+
+// notes returns the note on and off events of every loop of track t,
+// with the notes of every loop swung from the start of the loop.
+func (e *Encoder) notes(t drum.Track, note uint8, steps int, stepTicks float64, swing drum.Swing) []event {
+	var events []event
+	ppq := float64(e.PPQ)
+	for loop := 0; loop < e.Loops; loop++ {
+		loopStart := float64(loop*steps) * stepTicks
+		for i := range t.Sequence {
+			s := t.Step(i)
+			if s == (drum.Step{}) {
+				continue
+			}
+			start := (float64(i) + float64(s.Offset)/128) * stepTicks
+			span := stepTicks / float64(s.Ratchets)
+			length := span / 2
+			if e.NoteLength > 0 {
+				length = math.Min(float64(e.NoteLength), span)
+			}
+			for r := 0; r < int(s.Ratchets); r++ {
+				at := loopStart + swing.Apply((start+float64(r)*span)/ppq)*ppq
+				on := int(math.Round(math.Max(0, at)))
+				off := on + int(math.Max(1, math.Round(length)))
+				events = append(events,
+					event{on, []byte{noteOn | Channel, note, s.Velocity}},
+					event{off, []byte{noteOff | Channel, note, 0}})
+			}
+		}
+	}
+	return events
+}
+
+func tempoEvent(bpm float32) []byte {
+	usec := uint32(math.Round(60e6 / float64(bpm)))
+	return metaEvent(metaTempo, []byte{byte(usec >> 16), byte(usec >> 8), byte(usec)})
+}
+
+// timeSignatureEvent returns the time signature of the pattern,
+// or nil if its beats are not a power of two fraction of a whole note.
+func (e *Encoder) timeSignatureEvent(ts drum.TimeSignature) []byte {
+	if ts == (drum.TimeSignature{}) {
+		ts = drum.DefaultTimeSignature
+	}
+	// A beat lasts StepsPerBeat/StepsPerQuarter quarter notes,
+	// so the beat's note value is 4*StepsPerQuarter/StepsPerBeat.
+	if (4*e.StepsPerQuarter)%ts.StepsPerBeat != 0 {
+		return nil
+	}
+	value := 4 * e.StepsPerQuarter / ts.StepsPerBeat
+	power := 0
+	for ; 1<<uint(power) < value; power++ {
+	}
+	if 1<<uint(power) != value || ts.BeatsPerBar > 255 {
+		return nil
+	}
+	clocks := 24 * 4 / value
+	return metaEvent(metaTimeSignature, []byte{byte(ts.BeatsPerBar), byte(power), byte(clocks), 8})
+}
+
+func metaEvent(kind byte, data []byte) []byte {
+	b := []byte{meta, kind}
+	b = appendVarint(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+// encodeTrack returns the data of a track chunk holding events,
+// ended no earlier than tick end.
+func encodeTrack(events []event, end int) []byte {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].tick != events[j].tick {
+			return events[i].tick < events[j].tick
+		}
+		return events[i].order() < events[j].order()
+	})
+	var b []byte
+	tick := 0
+	for _, ev := range events {
+		b = appendVarint(b, uint32(ev.tick-tick))
+		b = append(b, ev.data...)
+		tick = ev.tick
+	}
+	if end < tick {
+		end = tick
+	}
+	b = appendVarint(b, uint32(end-tick))
+	return append(b, metaEvent(metaEndOfTrack, nil)...)
+}
+
+// appendVarint appends n as a MIDI variable-length quantity.
+func appendVarint(b []byte, n uint32) []byte {
+	var buf [5]byte
+	i := len(buf) - 1
+	buf[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		buf[i] = byte(n&0x7f) | 0x80
+	}
+	return append(b, buf[i:]...)
+}
+
+func writeChunk(w io.Writer, id string, data []byte) error {
+	if _, err := io.WriteString(w, id); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
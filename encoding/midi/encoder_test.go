@@ -0,0 +1,193 @@
+package midi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"path"
+	"testing"
+
+	"splice/encoding/drum"
+)
+
+func TestAppendVarint(t *testing.T) {
+	tData := []struct {
+		n        uint32
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{0x40, []byte{0x40}},
+		{0x7f, []byte{0x7f}},
+		{0x80, []byte{0x81, 0x00}},
+		{0x2000, []byte{0xc0, 0x00}},
+		{0x1fffff, []byte{0xff, 0xff, 0x7f}},
+		{0x0fffffff, []byte{0xff, 0xff, 0xff, 0x7f}},
+	}
+	for _, exp := range tData {
+		actual := appendVarint(nil, exp.n)
+		if !bytes.Equal(actual, exp.expected) {
+			t.Fatalf("Expected % x for %#x but received % x", exp.expected, exp.n, actual)
+		}
+	}
+}
+
+// chunks splits a Standard MIDI File into the data of its chunks.
+func chunks(t *testing.T, b []byte) (ids []string, data [][]byte) {
+	for len(b) > 0 {
+		if len(b) < 8 {
+			t.Fatalf("Truncated chunk header % x", b)
+		}
+		n := binary.BigEndian.Uint32(b[4:8])
+		ids = append(ids, string(b[:4]))
+		data = append(data, b[8:8+n])
+		b = b[8+n:]
+	}
+	return ids, data
+}
+
+func TestEncode(t *testing.T) {
+	p, err := drum.DecodeFile(path.Join("..", "drum", "patterns", "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits := 0
+	for _, track := range p.Tracks {
+		hits += bytes.Count(track.Sequence, []byte{1})
+	}
+	tData := []struct {
+		format int
+		loops  int
+		tracks int
+	}{
+		{0, 1, 1},
+		{1, 1, 1 + len(p.Tracks)},
+		{1, 3, 1 + len(p.Tracks)},
+	}
+	for _, exp := range tData {
+		b := new(bytes.Buffer)
+		e := NewEncoder(b)
+		e.Format = exp.format
+		e.Loops = exp.loops
+		e.PPQ = 480
+		if err := e.Encode(*p); err != nil {
+			t.Fatal(err)
+		}
+		ids, data := chunks(t, b.Bytes())
+		if len(ids) != 1+exp.tracks || ids[0] != "MThd" {
+			t.Fatalf("Expected a header and %v tracks but received %v", exp.tracks, ids)
+		}
+		header := []byte{0, byte(exp.format), 0, byte(exp.tracks), 480 >> 8, 480 & 0xff}
+		if !bytes.Equal(data[0], header) {
+			t.Fatalf("Expected header % x but received % x", header, data[0])
+		}
+		all := bytes.Join(data[1:], nil)
+		// 120 BPM is 500000 microseconds per quarter note.
+		if !bytes.Contains(data[1], []byte{0xff, 0x51, 0x03, 0x07, 0xa1, 0x20}) {
+			t.Fatalf("Expected a tempo event in % x", data[1])
+		}
+		if !bytes.Contains(data[1], []byte{0xff, 0x58, 0x04, 4, 2, 24, 8}) {
+			t.Fatalf("Expected a 4/4 time signature event in % x", data[1])
+		}
+		// Every kick is a note on of key 36 on channel 10 at the default velocity.
+		kicks := bytes.Count(all, []byte{0x99, 36, drum.DefaultVelocity})
+		if kicks != 4*exp.loops {
+			t.Fatalf("Expected %v kicks but received %v", 4*exp.loops, kicks)
+		}
+		if ons := bytes.Count(all, []byte{0x99}); ons < hits*exp.loops {
+			t.Fatalf("Expected %v note ons but received %v", hits*exp.loops, ons)
+		}
+		for i, track := range data[1:] {
+			if !bytes.HasSuffix(track, []byte{0xff, 0x2f, 0x00}) {
+				t.Fatalf("Track %v is not ended in % x", i, track)
+			}
+		}
+	}
+}
+
+func TestEncodeStepDetails(t *testing.T) {
+	seq := make([]byte, 16)
+	seq[4] = 1
+	steps := make([]drum.Step, 16)
+	steps[4] = drum.Step{Velocity: 90, Probability: 100, Ratchets: 2, Offset: 64}
+	p := drum.Pattern{Tempo: 120, Tracks: drum.Tracks{{ID: 1, Name: "snare", Sequence: seq, Steps: steps}}}
+	b := new(bytes.Buffer)
+	e := NewEncoder(b)
+	e.Format = 0
+	if err := e.Encode(p); err != nil {
+		t.Fatal(err)
+	}
+	_, data := chunks(t, b.Bytes())
+	// Steps are 24 ticks, so the ratchets start half a step late at 108
+	// and 120 ticks, each lasting 6 ticks.
+	expected := []byte{
+		0x6c, 0x99, 38, 90,
+		0x06, 0x89, 38, 0,
+		0x06, 0x99, 38, 90,
+		0x06, 0x89, 38, 0,
+	}
+	if !bytes.Contains(data[1], expected) {
+		t.Fatalf("Expected ratchets % x in % x", expected, data[1])
+	}
+}
+
+func TestEncodeSwing(t *testing.T) {
+	p := drum.Pattern{Tempo: 120, Swing: drum.Swing{Amount: 75}, Tracks: drum.Tracks{*drum.NewTrack()}}
+	p.Tracks[0].Name = "kick"
+	p.Tracks[0].Sequence[0] = 1
+	p.Tracks[0].Sequence[1] = 1
+	b := new(bytes.Buffer)
+	e := NewEncoder(b)
+	e.Format = 0
+	if err := e.Encode(p); err != nil {
+		t.Fatal(err)
+	}
+	_, data := chunks(t, b.Bytes())
+	// The second sixteenth note is moved from 24 to 36 ticks.
+	expected := []byte{
+		0x00, 0x99, 36, drum.DefaultVelocity,
+		0x0c, 0x89, 36, 0,
+		0x18, 0x99, 36, drum.DefaultVelocity,
+	}
+	if !bytes.Contains(data[1], expected) {
+		t.Fatalf("Expected swung notes % x in % x", expected, data[1])
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	p := drum.Pattern{Tempo: 120, Tracks: drum.Tracks{*drum.NewTrack()}}
+	p.Tracks[0].Name = "theremin"
+	if err := NewEncoder(new(bytes.Buffer)).Encode(p); err == nil {
+		t.Fatal("Expected an error encoding a track without a note")
+	}
+	e := NewEncoder(new(bytes.Buffer))
+	e.DrumMap = DrumMap{IDs: map[uint32]uint8{0: 81}}
+	if err := e.Encode(p); err != nil {
+		t.Fatalf("Expected the track to be mapped by ID - %v", err)
+	}
+	p.Tempo = 0
+	if err := e.Encode(p); err == nil {
+		t.Fatal("Expected an error encoding a pattern without tempo")
+	}
+	p.Tempo = float32(math.NaN())
+	if err := e.Encode(p); err == nil {
+		t.Fatal("Expected an error encoding a pattern with a NaN tempo")
+	}
+}
+
+func TestDrumMapNote(t *testing.T) {
+	tData := []struct {
+		track drum.Track
+		note  uint8
+	}{
+		{drum.Track{Name: "kick"}, 36},
+		{drum.Track{Name: "Low Conga"}, 64},
+		{drum.Track{Name: "HH Open"}, 46},
+		{drum.Track{Name: "HiHat"}, 42},
+	}
+	for _, exp := range tData {
+		note, ok := GeneralMIDI.Note(exp.track)
+		if !ok || note != exp.note {
+			t.Fatalf("Expected note %v for %q but received %v", exp.note, exp.track.Name, note)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package midi converts drum patterns to and from Standard MIDI Files.
+// See www.midi.org/specifications for the file format.
+package midi
+
+import (
+	"strings"
+	"unicode"
+
+	"splice/encoding/drum"
+)
+
+// Channel is the zero-based MIDI channel drum notes are written to,
+// which is channel 10 in General MIDI.
+const Channel = 9
+
+// A DrumMap assigns MIDI note numbers to drum tracks.
+type DrumMap struct {
+	// IDs maps track IDs to notes and takes precedence over Names.
+	IDs map[uint32]uint8
+	// Names maps track names to notes. Names are compared ignoring case,
+	// spaces and punctuation so that "hh-open" matches "HH Open".
+	Names map[string]uint8
+}
+
+// Note returns the note number of track t.
+func (m DrumMap) Note(t drum.Track) (note uint8, ok bool) {
+	if note, ok = m.IDs[t.ID]; ok {
+		return note, ok
+	}
+	for name, note := range m.Names {
+		if normalize(name) == normalize(t.Name) {
+			return note, true
+		}
+	}
+	return 0, false
+}
+
+// Track returns the ID and name of the track for note.
+// The ID is the lowest ID mapped to note, or the note number itself
+// if note is only mapped by name. The name is the alphabetically
+// first name mapped to note, if any.
+func (m DrumMap) Track(note uint8) (id uint32, name string, ok bool) {
+	id = uint32(note)
+	var byID, byName bool
+	for i, n := range m.IDs {
+		if n == note && (!byID || i < id) {
+			id, byID = i, true
+		}
+	}
+	for s, n := range m.Names {
+		if n == note && (!byName || s < name) {
+			name, byName = s, true
+		}
+	}
+	return id, name, byID || byName
+}
+
+func normalize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// GeneralMIDI maps the instrument names used by drum machines
+// to the General MIDI percussion key map.
+var GeneralMIDI = DrumMap{
+	Names: map[string]uint8{
+		"subkick":    35,
+		"kick":       36,
+		"rimshot":    37,
+		"snare":      38,
+		"clap":       39,
+		"hh-close":   42,
+		"hihat":      42,
+		"hh-pedal":   44,
+		"low-tom":    45,
+		"hh-open":    46,
+		"mid-tom":    47,
+		"crash":      49,
+		"hi-tom":     50,
+		"ride":       51,
+		"tambourine": 54,
+		"cowbell":    56,
+		"hi-conga":   63,
+		"low conga":  64,
+		"maracas":    70,
+		"claves":     75,
+	},
+}
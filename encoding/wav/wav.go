@@ -0,0 +1,209 @@
+// Package wav reads and writes audio as RIFF WAVE files of PCM samples.
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+const (
+	formatPCM   = 1
+	formatFloat = 3
+	// formatExtensible defers to a subformat GUID,
+	// whose first two bytes are formatPCM or formatFloat.
+	formatExtensible = 0xfffe
+)
+
+// Audio is a series of interleaved samples from -1 to 1.
+type Audio struct {
+	SampleRate int
+	Channels   int
+	Samples    []float32
+}
+
+// Frames returns the number of samples per channel.
+func (a Audio) Frames() int {
+	if a.Channels == 0 {
+		return 0
+	}
+	return len(a.Samples) / a.Channels
+}
+
+// Mono returns the audio mixed down to a single channel.
+func (a Audio) Mono() []float32 {
+	if a.Channels == 1 {
+		return a.Samples
+	}
+	mono := make([]float32, a.Frames())
+	for i := range mono {
+		var sum float32
+		for c := 0; c < a.Channels; c++ {
+			sum += a.Samples[i*a.Channels+c]
+		}
+		mono[i] = sum / float32(a.Channels)
+	}
+	return mono
+}
+
+type formatChunk struct {
+	Format        uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+// ErrFormat means the input is not a WAVE file this package can read.
+var ErrFormat = errors.New("wav: unsupported format")
+
+// Decode reads a WAVE file of 8, 16, 24 or 32 bit integer
+// or 32 bit floating point samples.
+func Decode(r io.Reader) (Audio, error) {
+	var riff struct {
+		ID     [4]byte
+		Length uint32
+		Format [4]byte
+	}
+	if err := binary.Read(r, binary.LittleEndian, &riff); err != nil {
+		return Audio{}, err
+	}
+	if string(riff.ID[:]) != "RIFF" || string(riff.Format[:]) != "WAVE" {
+		return Audio{}, ErrFormat
+	}
+	// Bytes of the RIFF chunk following the WAVE identifier.
+	remaining := int64(riff.Length) - 4
+	var f *formatChunk
+	for {
+		var chunk struct {
+			ID     [4]byte
+			Length uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &chunk); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("wav: no data chunk")
+			}
+			return Audio{}, err
+		}
+		remaining -= 8
+		length := int64(chunk.Length)
+		if length > remaining {
+			return Audio{}, ErrFormat
+		}
+		// The RIFF size is no more trustworthy than the chunk length.
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, r, length+length%2); err != nil {
+			return Audio{}, io.ErrUnexpectedEOF
+		}
+		remaining -= length + length%2
+		data := buf.Bytes()[:length]
+		switch string(chunk.ID[:]) {
+		case "fmt ":
+			f = new(formatChunk)
+			if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, f); err != nil {
+				return Audio{}, ErrFormat
+			}
+			if f.Format == formatExtensible && len(data) >= 26 {
+				f.Format = binary.LittleEndian.Uint16(data[24:])
+			}
+		case "data":
+			if f == nil {
+				return Audio{}, fmt.Errorf("wav: data chunk precedes format chunk")
+			}
+			return decodeSamples(*f, data)
+		}
+	}
+}
+
+func decodeSamples(f formatChunk, data []byte) (Audio, error) {
+	a := Audio{SampleRate: int(f.SampleRate), Channels: int(f.Channels)}
+	width := int(f.BitsPerSample+7) / 8
+	if a.Channels < 1 || a.SampleRate < 1 || width < 1 {
+		return a, ErrFormat
+	}
+	a.Samples = make([]float32, len(data)/width)
+	for i := range a.Samples {
+		b := data[i*width : (i+1)*width]
+		switch {
+		case f.Format == formatFloat && width == 4:
+			a.Samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(b))
+		case f.Format == formatPCM && width == 1:
+			a.Samples[i] = float32(int(b[0])-128) / 128
+		case f.Format == formatPCM && width <= 4:
+			// Sign extend the little-endian integer from its top byte.
+			n := int32(int8(b[width-1]))
+			for j := width - 2; j >= 0; j-- {
+				n = n<<8 | int32(b[j])
+			}
+			a.Samples[i] = float32(float64(n) / float64(uint32(1)<<uint(8*width-1)))
+		default:
+			return a, ErrFormat
+		}
+	}
+	return a, nil
+}
+
+// Encode writes audio as a WAVE file of 16 or 24 bit integer samples.
+// Samples outside of -1 to 1 are clipped.
+func Encode(w io.Writer, a Audio, bitsPerSample int) error {
+	if bitsPerSample != 16 && bitsPerSample != 24 {
+		return fmt.Errorf("wav: unsupported sample size of %d bits", bitsPerSample)
+	}
+	if a.Channels < 1 || a.SampleRate < 1 {
+		return fmt.Errorf("wav: %d channels at %d Hz", a.Channels, a.SampleRate)
+	}
+	width := bitsPerSample / 8
+	f := formatChunk{
+		Format:        formatPCM,
+		Channels:      uint16(a.Channels),
+		SampleRate:    uint32(a.SampleRate),
+		ByteRate:      uint32(a.SampleRate * a.Channels * width),
+		BlockAlign:    uint16(a.Channels * width),
+		BitsPerSample: uint16(bitsPerSample),
+	}
+	data := make([]byte, 0, len(a.Samples)*width)
+	max := float64(int(1)<<uint(bitsPerSample-1) - 1)
+	for _, s := range a.Samples {
+		n := int32(math.Round(math.Max(-1, math.Min(1, float64(s))) * max))
+		for j := 0; j < width; j++ {
+			data = append(data, byte(n>>uint(8*j)))
+		}
+	}
+	b := new(bytes.Buffer)
+	b.WriteString("WAVE")
+	writeChunk(b, "fmt ", f)
+	writeChunk(b, "data", data)
+	if b.Len()%2 != 0 {
+		b.WriteByte(0)
+	}
+	if _, err := io.WriteString(w, "RIFF"); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(b.Len())); err != nil {
+		return err
+	}
+	_, err := b.WriteTo(w)
+	return err
+}
+
+func writeChunk(b *bytes.Buffer, id string, data interface{}) {
+	payload := new(bytes.Buffer)
+	binary.Write(payload, binary.LittleEndian, data)
+	b.WriteString(id)
+	binary.Write(b, binary.LittleEndian, uint32(payload.Len()))
+	payload.WriteTo(b)
+}
+
+// ReadFile decodes the WAVE file found at path.
+func ReadFile(path string) (Audio, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Audio{}, err
+	}
+	return Decode(bytes.NewReader(b))
+}
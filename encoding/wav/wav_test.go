@@ -0,0 +1,59 @@
+package wav
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := Audio{SampleRate: 22050, Channels: 2, Samples: []float32{0, 0.5, -0.5, 1, -1, 0.25, 2, -2}}
+	clipped := []float32{0, 0.5, -0.5, 1, -1, 0.25, 1, -1}
+	for _, bits := range []int{16, 24} {
+		b := new(bytes.Buffer)
+		if err := Encode(b, in, bits); err != nil {
+			t.Fatal(err)
+		}
+		if expected := 44 + len(in.Samples)*bits/8; b.Len() != expected {
+			t.Fatalf("Expected %v bytes for %v bits but received %v", expected, bits, b.Len())
+		}
+		out, err := Decode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.SampleRate != in.SampleRate || out.Channels != in.Channels || out.Frames() != 4 {
+			t.Fatalf("Expected %v frames of %v channels at %v Hz but received %v, %v and %v",
+				4, in.Channels, in.SampleRate, out.Frames(), out.Channels, out.SampleRate)
+		}
+		for i, s := range out.Samples {
+			if math.Abs(float64(s-clipped[i])) > 1e-4 {
+				t.Fatalf("Expected sample %v to be %v at %v bits but received %v", i, clipped[i], bits, s)
+			}
+		}
+	}
+}
+
+func TestMono(t *testing.T) {
+	a := Audio{SampleRate: 8000, Channels: 2, Samples: []float32{1, 0, 0.5, 0.5, -1, 1}}
+	expected := []float32{0.5, 0.5, 0}
+	for i, s := range a.Mono() {
+		if s != expected[i] {
+			t.Fatalf("Expected %v but received %v", expected, a.Mono())
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"RIFF\x04\x00\x00\x00AVI ",
+		"RIFF\x04\x00\x00\x00WAVE",
+		"RIFF\x0c\x00\x00\x00WAVEdata\x00\x00\x00\x00",
+		"RIFF\x10\x00\x00\x00WAVEdata\xff\xff\xff\xff\x00\x00\x00\x00",
+		"RIFF\xff\xff\xff\xffWAVEdata\xff\xff\xff\xff\x00\x00\x00\x00",
+	} {
+		if _, err := Decode(bytes.NewReader([]byte(input))); err == nil {
+			t.Fatalf("Expected an error decoding %q", input)
+		}
+	}
+}
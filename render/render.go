@@ -0,0 +1,187 @@
+// Package render plays drum patterns offline into audio.
+package render
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+
+	"splice/encoding/drum"
+	"splice/encoding/wav"
+	"splice/synth"
+)
+
+// A Level sets the gain and stereo position of a track in the mix.
+type Level struct {
+	Gain float64 // amplitude factor, 1 leaves the track unchanged
+	Pan  float64 // from -1 for hard left to 1 for hard right
+}
+
+// A Renderer mixes the hits of a pattern's tracks into stereo audio.
+//
+// Every hit triggers the sample of its track, scaled by the hit's velocity
+// and the track's level, at the time the pattern's swing moves it to.
+// Tracks without a sample fall back to the voice of package synth their
+// name suggests, or a rimshot. Hits with a probability below 100 percent
+// sound depending on a pseudo-random sequence seeded by Seed.
+type Renderer struct {
+	SampleRate int
+	// StepsPerQuarter is the number of pattern steps per quarter note.
+	StepsPerQuarter int
+	// Loops is the number of times the pattern is played.
+	Loops int
+	// Samples are mono samples at SampleRate for tracks by ID.
+	Samples map[uint32][]float32
+	// NamedSamples are mono samples at SampleRate for tracks by name,
+	// compared ignoring case. Samples by ID take precedence.
+	NamedSamples map[string][]float32
+	// Levels set the gain and pan of tracks by ID.
+	// Tracks without a level are played centered at unity gain.
+	Levels map[uint32]Level
+	Seed   int64
+}
+
+// NewRenderer returns a renderer playing a single loop of
+// sixteenth note steps at 44.1 kHz.
+func NewRenderer() *Renderer {
+	return &Renderer{
+		SampleRate:      44100,
+		StepsPerQuarter: 4,
+		Loops:           1,
+		Samples:         make(map[uint32][]float32),
+		NamedSamples:    make(map[string][]float32),
+		Levels:          make(map[uint32]Level),
+	}
+}
+
+// LoadSample reads a WAVE file for use as a sample,
+// mixed down to mono and resampled to the renderer's sample rate.
+func (r *Renderer) LoadSample(path string) ([]float32, error) {
+	a, err := wav.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return resample(a.Mono(), a.SampleRate, r.SampleRate), nil
+}
+
+// resample converts samples between rates by linear interpolation.
+func resample(samples []float32, from, to int) []float32 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+	n := int(int64(len(samples)) * int64(to) / int64(from))
+	out := make([]float32, n)
+	ratio := float64(from) / float64(to)
+	for i := range out {
+		x := float64(i) * ratio
+		j := int(x)
+		frac := float32(x - float64(j))
+		next := samples[len(samples)-1]
+		if j+1 < len(samples) {
+			next = samples[j+1]
+		}
+		out[i] = samples[j]*(1-frac) + next*frac
+	}
+	return out
+}
+
+// MaxDuration is the longest audio in seconds Render returns.
+const MaxDuration = 60 * 60
+
+// Render returns the stereo audio of the pattern played r.Loops times.
+// It returns an error if the audio would last longer than MaxDuration.
+func (r *Renderer) Render(p drum.Pattern) (wav.Audio, error) {
+	switch {
+	case r.SampleRate < 1:
+		return wav.Audio{}, fmt.Errorf("render: sample rate %d out of range", r.SampleRate)
+	case r.StepsPerQuarter < 1:
+		return wav.Audio{}, fmt.Errorf("render: steps per quarter note %d out of range", r.StepsPerQuarter)
+	case r.Loops < 1:
+		return wav.Audio{}, fmt.Errorf("render: loop count %d out of range", r.Loops)
+	case !(p.Tempo > 0) || math.IsInf(float64(p.Tempo), 1):
+		return wav.Audio{}, fmt.Errorf("render: tempo %v out of range", p.Tempo)
+	}
+	quarterFrames := float64(r.SampleRate) * 60 / float64(p.Tempo)
+	stepFrames := quarterFrames / float64(r.StepsPerQuarter)
+	length := math.Round(stepFrames * float64(p.Steps()) * float64(r.Loops))
+	if length > MaxDuration*float64(r.SampleRate) {
+		return wav.Audio{}, fmt.Errorf("render: audio of tempo %v and %d loops lasts longer than %d seconds",
+			p.Tempo, r.Loops, MaxDuration)
+	}
+	frames := int(length)
+	out := wav.Audio{SampleRate: r.SampleRate, Channels: 2, Samples: make([]float32, 2*frames)}
+	rnd := rand.New(rand.NewSource(r.Seed))
+	type voice struct {
+		sample      []float32
+		left, right float64
+	}
+	voices := make([]voice, len(p.Tracks))
+	for i, t := range p.Tracks {
+		level, ok := r.Levels[t.ID]
+		if !ok {
+			level = Level{Gain: 1}
+		}
+		// Pan with constant power.
+		angle := (math.Max(-1, math.Min(1, level.Pan)) + 1) * math.Pi / 4
+		voices[i] = voice{r.sample(t), level.Gain * math.Cos(angle), level.Gain * math.Sin(angle)}
+	}
+	// Hits are played in the order a sequencer.Sequencer plays them,
+	// so that both draw the same pseudo-random sequence.
+	for loop := 0; loop < r.Loops; loop++ {
+		loopStart := float64(loop*p.Steps()) * stepFrames
+		for i := 0; i < p.Steps(); i++ {
+			for j, t := range p.Tracks {
+				if i >= len(t.Sequence) {
+					continue
+				}
+				s := t.Step(i)
+				if s == (drum.Step{}) || (s.Probability < 100 && rnd.Intn(100) >= int(s.Probability)) {
+					continue
+				}
+				v := voices[j]
+				start := (float64(i) + float64(s.Offset)/128) * stepFrames
+				span := stepFrames / float64(s.Ratchets)
+				amp := float64(s.Velocity) / 127
+				for n := 0; n < int(s.Ratchets); n++ {
+					swung := p.Swing.Apply((start+float64(n)*span)/quarterFrames) * quarterFrames
+					at := int(math.Round(loopStart + swung))
+					mix(out.Samples, v.sample, at, float32(amp*v.left), float32(amp*v.right))
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// sample returns the sample triggered by the hits of track t.
+func (r *Renderer) sample(t drum.Track) []float32 {
+	if s, ok := r.Samples[t.ID]; ok {
+		return s
+	}
+	for name, s := range r.NamedSamples {
+		if strings.EqualFold(name, t.Name) {
+			return s
+		}
+	}
+	v, ok := synth.ForName(t.Name)
+	if !ok {
+		v = synth.Rimshot{}
+	}
+	return v.Render(r.SampleRate)
+}
+
+// mix adds sample to the interleaved stereo frames of out from frame at on.
+func mix(out, sample []float32, at int, left, right float32) {
+	for i, v := range sample {
+		frame := at + i
+		if frame < 0 {
+			continue
+		}
+		if 2*frame >= len(out) {
+			return
+		}
+		out[2*frame] += v * left
+		out[2*frame+1] += v * right
+	}
+}
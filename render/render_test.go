@@ -0,0 +1,117 @@
+package render
+
+import (
+	"math"
+	"path"
+	"testing"
+
+	"splice/encoding/drum"
+)
+
+func TestRender(t *testing.T) {
+	p, err := drum.DecodeFile(path.Join("..", "encoding", "drum", "patterns", "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRenderer()
+	r.SampleRate = 8000
+	r.Loops = 2
+	// A single full scale click for the kick, panned hard left.
+	r.Samples[0] = []float32{1}
+	r.Levels[0] = Level{Gain: 0.5, Pan: -1}
+	p.Tracks = p.Tracks[:1]
+	a, err := r.Render(*p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 120 BPM sixteenth note steps last 1000 frames at 8 kHz.
+	if a.Channels != 2 || a.Frames() != 2*16*1000 {
+		t.Fatalf("Expected %v stereo frames but received %v of %v channels", 2*16*1000, a.Frames(), a.Channels)
+	}
+	amp := float32(0.5 * drum.DefaultVelocity / 127.0)
+	for frame := 0; frame < a.Frames(); frame++ {
+		left, right := a.Samples[2*frame], a.Samples[2*frame+1]
+		expected := float32(0)
+		if frame%4000 == 0 {
+			expected = amp
+		}
+		if math.Abs(float64(left-expected)) > 1e-6 || math.Abs(float64(right)) > 1e-6 {
+			t.Fatalf("Expected frame %v to be %v, 0 but received %v, %v", frame, expected, left, right)
+		}
+	}
+}
+
+func TestRenderInvalidTempo(t *testing.T) {
+	p := drum.Pattern{Tracks: drum.Tracks{{Name: "kick", Sequence: []byte{1, 0, 0, 0}}}}
+	for _, tempo := range []float64{0, -120, math.NaN(), math.Inf(1), 1e-30} {
+		p.Tempo = float32(tempo)
+		if _, err := NewRenderer().Render(p); err == nil {
+			t.Fatalf("Expected an error rendering tempo %v", p.Tempo)
+		}
+	}
+}
+
+func TestRenderFallback(t *testing.T) {
+	p, err := drum.DecodeFile(path.Join("..", "encoding", "drum", "patterns", "pattern_4.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRenderer()
+	a, err := r.Render(*p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peak := 0.0
+	for _, s := range a.Samples {
+		peak = math.Max(peak, math.Abs(float64(s)))
+	}
+	if peak == 0 {
+		t.Fatal("Expected the synthesized voices to be audible")
+	}
+	b, err := r.Render(*p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range a.Samples {
+		if a.Samples[i] != b.Samples[i] {
+			t.Fatalf("Expected rendering to be deterministic but sample %v differs", i)
+		}
+	}
+}
+
+func TestRenderProbability(t *testing.T) {
+	seq := make([]byte, 16)
+	steps := make([]drum.Step, 16)
+	for i := range seq {
+		seq[i] = 1
+		steps[i] = drum.Step{Velocity: 127, Probability: 50, Ratchets: 1}
+	}
+	p := drum.Pattern{Tempo: 120, Tracks: drum.Tracks{{Name: "kick", Sequence: seq, Steps: steps}}}
+	r := NewRenderer()
+	r.SampleRate = 8000
+	r.Loops = 8
+	r.Samples[0] = []float32{1}
+	a, err := r.Render(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits := 0
+	for frame := 0; frame < a.Frames(); frame += 1000 {
+		if a.Samples[2*frame] != 0 {
+			hits++
+		}
+	}
+	if hits < 32 || hits > 96 {
+		t.Fatalf("Expected about half of 128 hits to sound but %v did", hits)
+	}
+}
+
+func TestResample(t *testing.T) {
+	out := resample([]float32{0, 1, 0, -1}, 4, 8)
+	expected := []float32{0, 0.5, 1, 0.5, 0, -0.5, -1, -1}
+	for i := range expected {
+		if out[i] != expected[i] {
+			t.Fatalf("Expected %v but received %v", expected, out)
+		}
+	}
+}
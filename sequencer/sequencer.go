@@ -0,0 +1,330 @@
+// Package sequencer plays drum patterns in real time.
+package sequencer
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"splice/encoding/drum"
+)
+
+// An Event is a hit of a track at a step of the pattern.
+type Event struct {
+	Track    uint32 // ID of the track
+	Step     int    // index of the step in the pattern
+	Loop     int    // number of times the pattern has been played before
+	Velocity uint8
+	// Time is when the step is due. The other details of the step
+	// can be found with the Step method of the track.
+	Time time.Time
+}
+
+// A Sequencer plays a pattern by emitting events for its hits as each
+// step falls due.
+//
+// The time of every step is derived from the time the sequencer was
+// started or its tempo last changed, rather than from the step before,
+// so that the clock does not drift however long it plays. The events of
+// steps swung by the pattern's swing are delayed accordingly.
+//
+// Hits with a probability below 100 percent sound depending on a
+// pseudo-random sequence seeded by Seed, which restarts whenever the
+// sequencer plays the first step of the first loop. Played from there
+// without seeking, a sequencer sounds the same hits as a render.Renderer
+// with the same Seed.
+type Sequencer struct {
+	// StepsPerQuarter is the number of pattern steps per quarter note.
+	StepsPerQuarter int
+	// Loops is the number of times the pattern is played,
+	// or zero to play it until stopped.
+	Loops int
+	// Handler is called with the events of each step from the goroutine
+	// running the clock. Without a Handler, events are sent on the
+	// channel returned by Events. The Handler must not call Pause or Stop,
+	// which wait for it to return.
+	Handler func(Event)
+	Seed    int64
+
+	clock   Clock
+	pattern *drum.Pattern
+	events  chan Event
+
+	mu         sync.Mutex
+	tempo      float32
+	step, loop int
+	seek       int     // step to move to once the step playing ends, or -1
+	drawn      bool    // whether the hits of the step have been drawn
+	pending    []Event // drawn events of the step yet to be emitted
+	rnd        *rand.Rand
+	stop       chan struct{} // closed to stop the clock, nil while it is stopped
+	done       chan struct{} // closed once the clock has stopped
+}
+
+// New returns a sequencer playing p once at p.Tempo in sixteenth note steps,
+// timed by clock. The pattern must not be modified while it is playing.
+func New(p *drum.Pattern, clock Clock) *Sequencer {
+	done := make(chan struct{})
+	close(done)
+	return &Sequencer{
+		StepsPerQuarter: 4,
+		Loops:           1,
+		clock:           clock,
+		pattern:         p,
+		events:          make(chan Event),
+		tempo:           p.Tempo,
+		seek:            -1,
+		done:            done,
+	}
+}
+
+// Events returns the channel receiving the events of a sequencer without
+// a Handler. The clock waits for each event to be received.
+func (s *Sequencer) Events() <-chan Event {
+	return s.events
+}
+
+// Done returns a channel that is closed once the clock stops,
+// because the sequencer was paused or stopped or played its last loop.
+func (s *Sequencer) Done() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done
+}
+
+// Position returns the loop and step playing, or that will play next
+// between steps. A step paused before all its events were emitted
+// emits the rest once the sequencer starts again.
+func (s *Sequencer) Position() (loop, step int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loop, s.step
+}
+
+// Start starts the clock, playing from the current position at once.
+// A sequencer that has played its last loop starts over.
+func (s *Sequencer) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.stop != nil:
+		return fmt.Errorf("sequencer: already started")
+	case s.tempo <= 0 || math.IsInf(float64(s.tempo), 0) || math.IsNaN(float64(s.tempo)):
+		return fmt.Errorf("sequencer: tempo %v out of range", s.tempo)
+	case s.StepsPerQuarter < 1:
+		return fmt.Errorf("sequencer: steps per quarter note %d out of range", s.StepsPerQuarter)
+	case s.pattern.Steps() < 1:
+		return fmt.Errorf("sequencer: pattern has no steps")
+	}
+	if s.finished() {
+		s.moveTo(0, 0)
+	}
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	go s.run(s.clock.Now(), s.StepsPerQuarter, s.stop, s.done)
+	return nil
+}
+
+// Pause stops the clock, keeping the position to resume from.
+// No events are emitted once it returns.
+func (s *Sequencer) Pause() {
+	s.mu.Lock()
+	done := s.halt()
+	s.mu.Unlock()
+	<-done
+}
+
+// Stop stops the clock and rewinds to the first step of the first loop.
+// No events are emitted once it returns.
+func (s *Sequencer) Stop() {
+	s.mu.Lock()
+	done := s.halt()
+	s.moveTo(0, 0)
+	s.mu.Unlock()
+	<-done
+}
+
+// halt stops the clock and returns the channel closed once it has stopped.
+func (s *Sequencer) halt() <-chan struct{} {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	return s.done
+}
+
+// moveTo moves to step of loop, dropping the events of the step playing.
+func (s *Sequencer) moveTo(loop, step int) {
+	s.loop, s.step, s.seek = loop, step, -1
+	s.drawn, s.pending = false, nil
+}
+
+// Seek moves to step of the current loop. While the clock is running,
+// the step plays once the step currently playing ends.
+func (s *Sequencer) Seek(step int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if step < 0 || step >= s.pattern.Steps() {
+		return fmt.Errorf("sequencer: step %d out of range", step)
+	}
+	if s.stop != nil {
+		s.seek = step
+		return nil
+	}
+	s.moveTo(s.loop, step)
+	return nil
+}
+
+// SetTempo changes the tempo in beats per minute.
+// While the clock is running, the change takes effect at the next step.
+func (s *Sequencer) SetTempo(bpm float32) error {
+	if bpm <= 0 || math.IsInf(float64(bpm), 0) || math.IsNaN(float64(bpm)) {
+		return fmt.Errorf("sequencer: tempo %v out of range", bpm)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tempo = bpm
+	return nil
+}
+
+// Tempo returns the tempo in beats per minute.
+func (s *Sequencer) Tempo() float32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tempo
+}
+
+func (s *Sequencer) finished() bool {
+	return s.Loops > 0 && s.loop >= s.Loops
+}
+
+// run plays steps from start until stop is closed or the last loop ends.
+func (s *Sequencer) run(start time.Time, stepsPerQuarter int, stop, done chan struct{}) {
+	defer close(done)
+	// Steps are timed by counting them from an anchor,
+	// which moves whenever the tempo changes.
+	anchor, n := start, 0
+	var tempo float32
+	for {
+		s.mu.Lock()
+		if s.stop != stop {
+			s.mu.Unlock()
+			return
+		}
+		if s.finished() {
+			s.stop = nil
+			s.mu.Unlock()
+			return
+		}
+		if s.tempo != tempo {
+			anchor, n = stepTime(anchor, n, tempo, stepsPerQuarter), 0
+			tempo = s.tempo
+		}
+		// Swing delays the events of a step past the start of the step.
+		quarters := float64(s.step) / float64(stepsPerQuarter)
+		delay := (s.pattern.Swing.Apply(quarters) - quarters) * float64(time.Minute) / float64(tempo)
+		at := stepTime(anchor, n, tempo, stepsPerQuarter).Add(time.Duration(math.Round(delay)))
+		if !s.drawn {
+			if s.rnd == nil || (s.loop == 0 && s.step == 0) {
+				s.rnd = rand.New(rand.NewSource(s.Seed))
+			}
+			s.pending, s.drawn = s.hits(at), true
+		}
+		// The step may have been paused before its events were emitted.
+		events := append([]Event(nil), s.pending...)
+		for i := range events {
+			events[i].Time = at
+		}
+		handler := s.Handler
+		s.mu.Unlock()
+
+		if len(events) > 0 {
+			select {
+			case <-s.clock.At(at):
+			case <-stop:
+				return
+			}
+		}
+		for _, e := range events {
+			if handler != nil {
+				// Pause and Stop wait for run to return, so that
+				// the handler is not called once they have returned.
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				handler(e)
+				s.emitted()
+				continue
+			}
+			select {
+			case s.events <- e:
+			case <-stop:
+				return
+			}
+			s.emitted()
+		}
+		s.mu.Lock()
+		s.next()
+		s.mu.Unlock()
+		n++
+		select {
+		case <-s.clock.At(stepTime(anchor, n, tempo, stepsPerQuarter)):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// emitted records that the first pending event of the step was emitted.
+func (s *Sequencer) emitted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Stop and Seek drop the events of a step they interrupt.
+	if s.drawn {
+		s.pending = s.pending[1:]
+	}
+}
+
+// next moves to the next step once the events of the step have been
+// emitted, unless Stop or Seek moved elsewhere in the meantime.
+func (s *Sequencer) next() {
+	switch {
+	case !s.drawn:
+	case s.seek >= 0:
+		s.moveTo(s.loop, s.seek)
+	default:
+		s.moveTo(s.loop, s.step+1)
+		if s.step >= s.pattern.Steps() {
+			s.loop, s.step = s.loop+1, 0
+		}
+	}
+}
+
+// stepTime returns the time of the nth step after anchor.
+func stepTime(anchor time.Time, n int, tempo float32, stepsPerQuarter int) time.Time {
+	if n == 0 {
+		return anchor
+	}
+	step := float64(time.Minute) / float64(tempo) / float64(stepsPerQuarter)
+	return anchor.Add(time.Duration(math.Round(float64(n) * step)))
+}
+
+// hits returns the events of the hits of the current step that sound,
+// due at the given time.
+func (s *Sequencer) hits(at time.Time) []Event {
+	var events []Event
+	for _, t := range s.pattern.Tracks {
+		if s.step >= len(t.Sequence) {
+			continue
+		}
+		step := t.Step(s.step)
+		if step == (drum.Step{}) || (step.Probability < 100 && s.rnd.Intn(100) >= int(step.Probability)) {
+			continue
+		}
+		events = append(events, Event{Track: t.ID, Step: s.step, Loop: s.loop, Velocity: step.Velocity, Time: at})
+	}
+	return events
+}
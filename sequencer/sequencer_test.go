@@ -0,0 +1,339 @@
+package sequencer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"splice/encoding/drum"
+	"splice/render"
+)
+
+const backup = `Saved with HW Version: 0.808-alpha
+Tempo: 120
+(0) kick	|x---|x---|x---|x---|
+(1) snare	|----|X---|----|x---|
+`
+
+// stepLength is the length of a sixteenth note step at 120 BPM.
+const stepLength = 125 * time.Millisecond
+
+var epoch = time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newSequencer(t *testing.T) (*Sequencer, *FakeClock) {
+	p, err := drum.NewPatternFromBackup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFakeClock(epoch)
+	return New(p, clock), clock
+}
+
+func receive(t *testing.T, s *Sequencer, expected ...Event) {
+	for _, e := range expected {
+		if actual := <-s.Events(); actual != e {
+			t.Fatalf("Expected %+v but received %+v", e, actual)
+		}
+	}
+}
+
+func hit(track uint32, loop, step int, velocity uint8, at time.Duration) Event {
+	return Event{Track: track, Step: step, Loop: loop, Velocity: velocity, Time: epoch.Add(at)}
+}
+
+func TestPlay(t *testing.T) {
+	s, clock := newSequencer(t)
+	s.Loops = 2
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err == nil {
+		t.Fatal("Expected an error starting a running sequencer")
+	}
+	clock.Advance(32 * stepLength)
+	for loop := 0; loop < 2; loop++ {
+		at := time.Duration(loop*16) * stepLength
+		receive(t, s,
+			hit(0, loop, 0, drum.DefaultVelocity, at),
+			hit(0, loop, 4, drum.DefaultVelocity, at+4*stepLength),
+			hit(1, loop, 4, drum.AccentVelocity, at+4*stepLength),
+			hit(0, loop, 8, drum.DefaultVelocity, at+8*stepLength),
+			hit(0, loop, 12, drum.DefaultVelocity, at+12*stepLength),
+			hit(1, loop, 12, drum.DefaultVelocity, at+12*stepLength))
+	}
+	<-s.Done()
+	if loop, step := s.Position(); loop != 2 || step != 0 {
+		t.Fatalf("Expected to finish at loop 2, step 0 but finished at %v, %v", loop, step)
+	}
+}
+
+func TestWaitForSteps(t *testing.T) {
+	s, clock := newSequencer(t)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	receive(t, s, hit(0, 0, 0, drum.DefaultVelocity, 0))
+	clock.Advance(4*stepLength - time.Nanosecond)
+	select {
+	case e := <-s.Events():
+		t.Fatalf("Expected no event before step 4 was due but received %+v", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Nanosecond)
+	receive(t, s, hit(0, 0, 4, drum.DefaultVelocity, 4*stepLength))
+}
+
+func TestSetTempo(t *testing.T) {
+	s, clock := newSequencer(t)
+	s.Loops = 0
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	receive(t, s, hit(0, 0, 0, drum.DefaultVelocity, 0))
+	// The step playing keeps its length, the following steps are halved.
+	if err := s.SetTempo(240); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(stepLength + 3*stepLength/2)
+	receive(t, s,
+		hit(0, 0, 4, drum.DefaultVelocity, stepLength+3*stepLength/2),
+		hit(1, 0, 4, drum.AccentVelocity, stepLength+3*stepLength/2))
+	if err := s.SetTempo(0); err == nil {
+		t.Fatal("Expected an error setting a tempo of 0")
+	}
+}
+
+func TestSwing(t *testing.T) {
+	s, clock := newSequencer(t)
+	s.pattern.Swing = drum.Swing{Amount: 75, Resolution: 8}
+	s.pattern.Tracks[0].Sequence[6] = 1
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	receive(t, s, hit(0, 0, 0, drum.DefaultVelocity, 0))
+	// The second eighth note of the second beat is moved from
+	// half way through the beat to three quarters.
+	clock.Advance(6*stepLength + stepLength/2)
+	select {
+	case e := <-s.Events():
+		if e.Step != 4 {
+			t.Fatalf("Expected step 4 but received %+v", e)
+		}
+		<-s.Events()
+	case <-time.After(time.Second):
+		t.Fatal("Expected the events of step 4")
+	}
+	select {
+	case e := <-s.Events():
+		t.Fatalf("Expected no event before the swung step was due but received %+v", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(stepLength / 2)
+	receive(t, s, hit(0, 0, 6, drum.DefaultVelocity, 7*stepLength))
+}
+
+func TestPauseSwing(t *testing.T) {
+	s, clock := newSequencer(t)
+	s.pattern.Swing = drum.Swing{Amount: 75, Resolution: 8}
+	s.pattern.Tracks[0].Sequence[6] = 1
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	clock.Advance(6 * stepLength)
+	receive(t, s,
+		hit(0, 0, 0, drum.DefaultVelocity, 0),
+		hit(0, 0, 4, drum.DefaultVelocity, 4*stepLength),
+		hit(1, 0, 4, drum.AccentVelocity, 4*stepLength))
+	// Wait for the swung step, then pause before its events are due.
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, step := s.Position(); step == 6 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected to reach step 6")
+		}
+	}
+	s.Pause()
+	if loop, step := s.Position(); loop != 0 || step != 6 {
+		t.Fatalf("Expected to pause at loop 0, step 6 but paused at %v, %v", loop, step)
+	}
+	clock.Advance(time.Second)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(stepLength)
+	receive(t, s, hit(0, 0, 6, drum.DefaultVelocity, time.Second+7*stepLength))
+}
+
+func TestPauseAndSeek(t *testing.T) {
+	s, clock := newSequencer(t)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, s, hit(0, 0, 0, drum.DefaultVelocity, 0))
+	s.Pause()
+	<-s.Done()
+	if loop, step := s.Position(); loop != 0 || step != 1 {
+		t.Fatalf("Expected to pause at loop 0, step 1 but paused at %v, %v", loop, step)
+	}
+	if err := s.Seek(12); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Seek(16); err == nil {
+		t.Fatal("Expected an error seeking past the last step")
+	}
+	clock.Advance(time.Second)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, s,
+		hit(0, 0, 12, drum.DefaultVelocity, time.Second),
+		hit(1, 0, 12, drum.DefaultVelocity, time.Second))
+	s.Stop()
+	<-s.Done()
+	if loop, step := s.Position(); loop != 0 || step != 0 {
+		t.Fatalf("Expected to stop at loop 0, step 0 but stopped at %v, %v", loop, step)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s, clock := newSequencer(t)
+	s.Loops = 1000
+	tempo := float32(97.3)
+	if err := s.SetTempo(tempo); err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	s.Handler = func(e Event) { events = append(events, e) }
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	<-s.Done()
+	if len(events) != 6*s.Loops {
+		t.Fatalf("Expected %v events but received %v", 6*s.Loops, len(events))
+	}
+	// The last step is timed from the start, not from the step before it.
+	last := events[len(events)-1]
+	steps := float64(16*(s.Loops-1) + 12)
+	expected := epoch.Add(time.Duration(steps*float64(time.Minute)/float64(tempo)/4 + 0.5))
+	if !last.Time.Equal(expected) {
+		t.Fatalf("Expected the last event at %v but received it at %v", expected, last.Time)
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	s, _ := newSequencer(t)
+	s.StepsPerQuarter = 0
+	if err := s.Start(); err == nil {
+		t.Fatal("Expected an error for 0 steps per quarter note")
+	}
+	p := drum.NewPattern()
+	if err := New(p, RealClock).Start(); err == nil {
+		t.Fatal("Expected an error for a tempo of 0")
+	}
+}
+
+func TestProbability(t *testing.T) {
+	p := drum.NewPattern()
+	p.Tempo = 120
+	for id := uint32(0); id < 2; id++ {
+		track := drum.Track{ID: id, Sequence: make([]byte, 16), Steps: make([]drum.Step, 16)}
+		for i := range track.Sequence {
+			track.Sequence[i] = 1
+			track.Steps[i] = drum.Step{Velocity: 127, Probability: 50, Ratchets: 1}
+		}
+		p.Tracks = append(p.Tracks, track)
+	}
+	play := func(seed int64) []Event {
+		clock := NewFakeClock(epoch)
+		s := New(p, clock)
+		s.Loops = 4
+		s.Seed = seed
+		var events []Event
+		s.Handler = func(e Event) { events = append(events, e) }
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Hour)
+		<-s.Done()
+		return events
+	}
+	events := play(7)
+	if len(events) < 32 || len(events) > 96 {
+		t.Fatalf("Expected about half of 128 hits to sound but %v did", len(events))
+	}
+	if again := play(7); fmt.Sprint(again) != fmt.Sprint(events) {
+		t.Fatalf("Expected the same seed to play the same hits but received %v and %v", events, again)
+	}
+	if other := play(8); fmt.Sprint(other) == fmt.Sprint(events) {
+		t.Fatal("Expected another seed to play other hits")
+	}
+
+	// Rendered with the same seed, the hits of track 0 sound on the left
+	// and those of track 1 on the right.
+	r := render.NewRenderer()
+	r.SampleRate = 8000
+	r.Loops = 4
+	r.Seed = 7
+	r.Samples[0], r.Samples[1] = []float32{1}, []float32{1}
+	r.Levels[0], r.Levels[1] = render.Level{Gain: 1, Pan: -1}, render.Level{Gain: 1, Pan: 1}
+	a, err := r.Render(*p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rendered []string
+	for frame := 0; frame < a.Frames(); frame += 1000 {
+		for id := uint32(0); id < 2; id++ {
+			if a.Samples[2*frame+int(id)] > 0.5 {
+				rendered = append(rendered, fmt.Sprint(id, frame/1000/16, frame/1000%16))
+			}
+		}
+	}
+	var played []string
+	for _, e := range events {
+		played = append(played, fmt.Sprint(e.Track, e.Loop, e.Step))
+	}
+	if fmt.Sprint(played) != fmt.Sprint(rendered) {
+		t.Fatalf("Expected the hits rendered with the same seed %v but played %v", rendered, played)
+	}
+}
+
+func TestPauseHandler(t *testing.T) {
+	s, clock := newSequencer(t)
+	s.Loops = 0
+	var mu sync.Mutex
+	paused := false
+	called := make(chan struct{}, 1)
+	s.Handler = func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if paused {
+			t.Errorf("Expected no events after Pause returned but received %+v", e)
+		}
+		select {
+		case called <- struct{}{}:
+		default:
+		}
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go clock.Advance(time.Hour)
+	<-called
+	s.Pause()
+	mu.Lock()
+	paused = true
+	mu.Unlock()
+	clock.Advance(time.Hour)
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("Expected the clock to have stopped once Pause returned")
+	}
+}
@@ -0,0 +1,128 @@
+// Package synth synthesizes drum sounds in the manner of analog drum machines.
+//
+// Voices are deterministic: rendering a voice with the same parameters
+// at the same sample rate always produces the same samples.
+package synth
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// A Voice synthesizes a single hit of a drum.
+type Voice interface {
+	// Render returns the hit as mono samples from -1 to 1.
+	Render(sampleRate int) []float32
+}
+
+// voices maps normalized instrument names to their voice.
+var voices = map[string]Voice{
+	"kick":     Kick{},
+	"bassdrum": Kick{},
+	"subkick":  Kick{Pitch: 35, Sweep: 90, Decay: 0.8},
+	"snare":    Snare{},
+	"clap":     Clap{},
+	"hhclose":  HiHat{},
+	"hhclosed": HiHat{},
+	"hihat":    HiHat{},
+	"hhopen":   HiHat{Decay: 0.4},
+	"cowbell":  Cowbell{},
+	"lowtom":   Tom{Pitch: 90},
+	"midtom":   Tom{Pitch: 130},
+	"hitom":    Tom{Pitch: 180},
+	"lowconga": Conga{Pitch: 200},
+	"midconga": Conga{Pitch: 290},
+	"hiconga":  Conga{Pitch: 370},
+	"maracas":  Maracas{},
+	"rimshot":  Rimshot{},
+}
+
+// ForName returns the voice of the instrument called name, such as
+// "kick", "hh-open" or "Low Conga". Names are compared ignoring case,
+// spaces and punctuation.
+func ForName(name string) (v Voice, ok bool) {
+	v, ok = voices[normalize(name)]
+	return v, ok
+}
+
+func normalize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// or returns v, or def if v is zero.
+func or(v, def float64) float64 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// buffer returns a buffer for a sound lasting as long as it takes
+// an exponential decay with time constant decay to fall by 60 dB.
+func buffer(sampleRate int, decay float64) []float32 {
+	return make([]float32, int(float64(sampleRate)*decay*math.Log(1000)))
+}
+
+// noise is a deterministic white noise source.
+type noise uint32
+
+// next returns a noise sample from -1 to 1.
+func (n *noise) next() float64 {
+	// xorshift32
+	x := uint32(*n)
+	if x == 0 {
+		x = 0x9e3779b9
+	}
+	x ^= x << 13
+	x ^= x >> 17
+	x ^= x << 5
+	*n = noise(x)
+	return float64(x)/float64(math.MaxUint32)*2 - 1
+}
+
+// A biquad is a second order IIR filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+// bandPass returns a constant peak gain band pass filter.
+func bandPass(sampleRate int, freq, q float64) *biquad {
+	w := 2 * math.Pi * freq / float64(sampleRate)
+	alpha := math.Sin(w) / (2 * q)
+	a0 := 1 + alpha
+	return &biquad{
+		b0: alpha / a0, b1: 0, b2: -alpha / a0,
+		a1: -2 * math.Cos(w) / a0, a2: (1 - alpha) / a0,
+	}
+}
+
+// highPass returns a Butterworth high pass filter.
+func highPass(sampleRate int, freq float64) *biquad {
+	w := 2 * math.Pi * freq / float64(sampleRate)
+	alpha := math.Sin(w) / math.Sqrt2
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	return &biquad{
+		b0: (1 + cos) / 2 / a0, b1: -(1 + cos) / a0, b2: (1 + cos) / 2 / a0,
+		a1: -2 * cos / a0, a2: (1 - alpha) / a0,
+	}
+}
+
+func (f *biquad) filter(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// clip limits samples to -1 to 1 with a soft knee.
+func clip(x float64) float32 {
+	return float32(math.Tanh(x))
+}
@@ -0,0 +1,69 @@
+package synth
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// names are the instruments of the drum pattern fixtures.
+var names = []string{
+	"kick", "snare", "clap", "hh-open", "hh-close", "cowbell",
+	"low-tom", "mid-tom", "hi-tom", "SubKick", "Kick", "Maracas", "Low Conga", "HiHat",
+}
+
+func TestForName(t *testing.T) {
+	for _, name := range names {
+		v, ok := ForName(name)
+		if !ok {
+			t.Fatalf("Expected a voice for %q", name)
+		}
+		samples := v.Render(44100)
+		if len(samples) == 0 {
+			t.Fatalf("Expected samples from the voice of %q", name)
+		}
+		peak := 0.0
+		for _, s := range samples {
+			peak = math.Max(peak, math.Abs(float64(s)))
+		}
+		if peak == 0 || peak > 1 {
+			t.Fatalf("Expected the peak of %q to be within (0, 1] but received %v", name, peak)
+		}
+	}
+	if _, ok := ForName("didgeridoo"); ok {
+		t.Fatal("Expected no voice for an unknown instrument")
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	for _, name := range names {
+		v, _ := ForName(name)
+		if a, b := v.Render(22050), v.Render(22050); !reflect.DeepEqual(a, b) {
+			t.Fatalf("Expected the voice of %q to render the same samples twice", name)
+		}
+	}
+	a, b := HiHat{Seed: 1}.Render(8000), HiHat{Seed: 2}.Render(8000)
+	if reflect.DeepEqual(a, b) {
+		t.Fatal("Expected different seeds to render different noise")
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	for _, name := range names {
+		v, _ := ForName(name)
+		low, high := len(v.Render(22050)), len(v.Render(44100))
+		if high < 2*low-1 || high > 2*low+1 {
+			t.Fatalf("Expected %q to last %v samples at twice the rate but received %v", name, 2*low, high)
+		}
+	}
+}
+
+func TestParameters(t *testing.T) {
+	short, long := HiHat{}.Render(8000), HiHat{Decay: 0.4}.Render(8000)
+	if len(long) <= len(short) {
+		t.Fatalf("Expected an open hi-hat to outlast a closed one but received %v and %v samples", len(long), len(short))
+	}
+	if !reflect.DeepEqual(Kick{}.Render(8000), Kick{Pitch: 50, Sweep: 150, PitchDecay: 0.03, Decay: 0.25}.Render(8000)) {
+		t.Fatal("Expected zero parameters to select the defaults")
+	}
+}
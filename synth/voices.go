@@ -0,0 +1,217 @@
+package synth
+
+import "math"
+
+// The zero value of every voice's parameters selects a default
+// modelled on the Roland TR-808. Times are in seconds and
+// frequencies in Hz.
+
+// A Kick is a sine whose pitch sweeps down from Sweep to Pitch.
+type Kick struct {
+	Pitch      float64 // final frequency, 50 by default
+	Sweep      float64 // initial frequency, 150 by default
+	PitchDecay float64 // time constant of the pitch envelope, 0.03 by default
+	Decay      float64 // time constant of the amplitude envelope, 0.25 by default
+}
+
+// Render implements Voice.
+func (k Kick) Render(sampleRate int) []float32 {
+	pitch, sweep := or(k.Pitch, 50), or(k.Sweep, 150)
+	pitchDecay, decay := or(k.PitchDecay, 0.03), or(k.Decay, 0.25)
+	out := buffer(sampleRate, decay)
+	phase := 0.0
+	for i := range out {
+		t := float64(i) / float64(sampleRate)
+		freq := pitch + (sweep-pitch)*math.Exp(-t/pitchDecay)
+		phase += 2 * math.Pi * freq / float64(sampleRate)
+		out[i] = clip(1.2 * math.Sin(phase) * math.Exp(-t/decay))
+	}
+	return out
+}
+
+// A Snare mixes a pitched body with high passed noise.
+type Snare struct {
+	Tone       float64 // frequency of the body, 180 by default
+	ToneDecay  float64 // time constant of the body, 0.05 by default
+	NoiseDecay float64 // time constant of the noise, 0.07 by default
+	Snappy     float64 // level of the noise relative to the body, 0.8 by default
+	Seed       uint32
+}
+
+// Render implements Voice.
+func (s Snare) Render(sampleRate int) []float32 {
+	tone, toneDecay := or(s.Tone, 180), or(s.ToneDecay, 0.05)
+	noiseDecay, snappy := or(s.NoiseDecay, 0.07), or(s.Snappy, 0.8)
+	out := buffer(sampleRate, math.Max(toneDecay, noiseDecay))
+	n := noise(s.Seed)
+	hp := highPass(sampleRate, 1500)
+	for i := range out {
+		t := float64(i) / float64(sampleRate)
+		body := math.Sin(2*math.Pi*tone*t) + 0.5*math.Sin(2*math.Pi*tone*1.6*t)
+		out[i] = clip(0.6*body*math.Exp(-t/toneDecay) + snappy*hp.filter(n.next())*math.Exp(-t/noiseDecay))
+	}
+	return out
+}
+
+// A HiHat is high passed noise ringing through a band pass filter.
+// A short decay makes a closed hi-hat, a long one an open hi-hat.
+type HiHat struct {
+	Decay  float64 // time constant of the amplitude envelope, 0.05 by default
+	Cutoff float64 // frequency of the high pass filter, 7000 by default
+	Seed   uint32
+}
+
+// Render implements Voice.
+func (h HiHat) Render(sampleRate int) []float32 {
+	decay, cutoff := or(h.Decay, 0.05), or(h.Cutoff, 7000)
+	out := buffer(sampleRate, decay)
+	n := noise(h.Seed)
+	hp := highPass(sampleRate, math.Min(cutoff, 0.45*float64(sampleRate)))
+	bp := bandPass(sampleRate, math.Min(10000, 0.45*float64(sampleRate)), 0.7)
+	for i := range out {
+		t := float64(i) / float64(sampleRate)
+		x := hp.filter(n.next())
+		out[i] = clip(0.8 * (x + bp.filter(x)) * math.Exp(-t/decay))
+	}
+	return out
+}
+
+// A Clap is a few quick bursts of band passed noise followed by a tail.
+type Clap struct {
+	Bursts  int     // number of bursts before the tail, 3 by default
+	Spacing float64 // time between bursts, 0.01 by default
+	Decay   float64 // time constant of the tail, 0.1 by default
+	Seed    uint32
+}
+
+// Render implements Voice.
+func (c Clap) Render(sampleRate int) []float32 {
+	bursts := c.Bursts
+	if bursts == 0 {
+		bursts = 3
+	}
+	spacing, decay := or(c.Spacing, 0.01), or(c.Decay, 0.1)
+	tail := float64(bursts) * spacing
+	// Lengthen the buffer by the bursts preceding the tail.
+	out := buffer(sampleRate, tail/math.Log(1000)+decay)
+	n := noise(c.Seed)
+	bp := bandPass(sampleRate, 1200, 1.5)
+	for i := range out {
+		t := float64(i) / float64(sampleRate)
+		env := math.Exp(-(t - tail) / decay)
+		if t < tail {
+			env = math.Exp(-math.Mod(t, spacing) / (spacing / 4))
+		}
+		out[i] = clip(2 * bp.filter(n.next()) * env)
+	}
+	return out
+}
+
+// A Cowbell is two detuned square waves through a band pass filter.
+type Cowbell struct {
+	Low   float64 // frequency of the lower square wave, 540 by default
+	High  float64 // frequency of the higher square wave, 800 by default
+	Decay float64 // time constant of the amplitude envelope, 0.12 by default
+}
+
+// Render implements Voice.
+func (c Cowbell) Render(sampleRate int) []float32 {
+	low, high, decay := or(c.Low, 540), or(c.High, 800), or(c.Decay, 0.12)
+	out := buffer(sampleRate, decay)
+	bp := bandPass(sampleRate, (low+high)/2, 1.2)
+	for i := range out {
+		t := float64(i) / float64(sampleRate)
+		x := square(low*t) + square(high*t)
+		// A sharp attack followed by a longer decay.
+		env := 0.6*math.Exp(-t/0.01) + 0.4*math.Exp(-t/decay)
+		out[i] = clip(1.5 * bp.filter(x) * env)
+	}
+	return out
+}
+
+// square returns a square wave at the given number of cycles.
+func square(cycles float64) float64 {
+	if math.Mod(cycles, 1) < 0.5 {
+		return 1
+	}
+	return -1
+}
+
+// A Tom is a sine that drops slightly in pitch with a little noise.
+type Tom struct {
+	Pitch float64 // frequency, 130 by default
+	Decay float64 // time constant of the amplitude envelope, 0.15 by default
+	Seed  uint32
+}
+
+// Render implements Voice.
+func (tm Tom) Render(sampleRate int) []float32 {
+	pitch, decay := or(tm.Pitch, 130), or(tm.Decay, 0.15)
+	out := buffer(sampleRate, decay)
+	n := noise(tm.Seed)
+	phase := 0.0
+	for i := range out {
+		t := float64(i) / float64(sampleRate)
+		phase += 2 * math.Pi * pitch * (1 + 0.3*math.Exp(-t/0.02)) / float64(sampleRate)
+		x := math.Sin(phase) + 0.05*n.next()*math.Exp(-t/0.01)
+		out[i] = clip(x * math.Exp(-t/decay))
+	}
+	return out
+}
+
+// A Conga is a short, high pitched tom.
+type Conga struct {
+	Pitch float64 // frequency, 290 by default
+	Decay float64 // time constant of the amplitude envelope, 0.08 by default
+}
+
+// Render implements Voice.
+func (c Conga) Render(sampleRate int) []float32 {
+	pitch, decay := or(c.Pitch, 290), or(c.Decay, 0.08)
+	out := buffer(sampleRate, decay)
+	phase := 0.0
+	for i := range out {
+		t := float64(i) / float64(sampleRate)
+		phase += 2 * math.Pi * pitch * (1 + 0.1*math.Exp(-t/0.005)) / float64(sampleRate)
+		out[i] = clip(math.Sin(phase) * math.Exp(-t/decay))
+	}
+	return out
+}
+
+// Maracas are a brief burst of high passed noise.
+type Maracas struct {
+	Decay float64 // time constant of the amplitude envelope, 0.02 by default
+	Seed  uint32
+}
+
+// Render implements Voice.
+func (m Maracas) Render(sampleRate int) []float32 {
+	decay := or(m.Decay, 0.02)
+	out := buffer(sampleRate, decay)
+	n := noise(m.Seed)
+	hp := highPass(sampleRate, math.Min(5000, 0.45*float64(sampleRate)))
+	for i := range out {
+		t := float64(i) / float64(sampleRate)
+		// Fade in over a millisecond as the beads hit the shell.
+		attack := math.Min(1, t/0.001)
+		out[i] = clip(0.7 * hp.filter(n.next()) * attack * math.Exp(-t/decay))
+	}
+	return out
+}
+
+// A Rimshot is a short click of two resonant tones.
+type Rimshot struct {
+	Decay float64 // time constant of the amplitude envelope, 0.01 by default
+}
+
+// Render implements Voice.
+func (r Rimshot) Render(sampleRate int) []float32 {
+	decay := or(r.Decay, 0.01)
+	out := buffer(sampleRate, decay)
+	for i := range out {
+		t := float64(i) / float64(sampleRate)
+		x := math.Sin(2*math.Pi*1700*t) + 0.6*math.Sin(2*math.Pi*480*t)
+		out[i] = clip(x * math.Exp(-t/decay))
+	}
+	return out
+}